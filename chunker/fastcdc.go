@@ -0,0 +1,97 @@
+package chunker
+
+import (
+	"io"
+)
+
+// Chunk size bounds, changing them moves every chunk boundary and disables
+// deduplication against blocks already stored on a destination.
+const (
+	MinSize = 512 * 1024
+	AvgSize = 1024 * 1024
+	MaxSize = 8 * 1024 * 1024
+)
+
+// FastCDC normalized chunking: a harder mask before AvgSize and an easier one
+// after it keep chunk sizes close to the average. The gear fingerprint is
+// shifted left so its top bits carry the most history, the masks test those.
+var (
+	maskS = uint64(1<<22-1) << (64 - 22)
+	maskL = uint64(1<<18-1) << (64 - 18)
+)
+
+var gear [256]uint64
+
+func init() {
+	// splitmix64 with a fixed seed, the table must stay the same between
+	// releases for chunks to deduplicate across backups.
+	seed := uint64(0x6361706976617261)
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Chunker splits a stream into content-defined chunks.
+type Chunker struct {
+	r   io.Reader
+	buf []byte
+	n   int
+	eof bool
+}
+
+func New(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, MaxSize)}
+}
+
+// Next returns the next chunk of the stream, or io.EOF once it is exhausted.
+func (c *Chunker) Next() ([]byte, error) {
+	if !c.eof && c.n < len(c.buf) {
+		read, err := io.ReadFull(c.r, c.buf[c.n:])
+		c.n += read
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+
+	cut := cutpoint(c.buf[:c.n])
+	chunk := make([]byte, cut)
+	copy(chunk, c.buf[:cut])
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return chunk, nil
+}
+
+func cutpoint(data []byte) int {
+	n := len(data)
+	if n <= MinSize {
+		return n
+	}
+	normal := AvgSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,63 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func split(t *testing.T, data []byte) [][]byte {
+	var chunks [][]byte
+	c := New(bytes.NewReader(data))
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestChunksRebuildInput(t *testing.T) {
+	data := make([]byte, 20*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := split(t, data)
+	assert.Greater(t, len(chunks), 1)
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), MaxSize)
+		if i < len(chunks)-1 {
+			assert.GreaterOrEqual(t, len(chunk), MinSize)
+		}
+	}
+	assert.Equal(t, data, bytes.Join(chunks, nil))
+}
+
+func TestChunkBoundariesSurviveInsert(t *testing.T) {
+	data := make([]byte, 20*1024*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	shifted := append([]byte{42}, data...)
+
+	seen := map[[16]byte]bool{}
+	for _, chunk := range split(t, data) {
+		seen[md5.Sum(chunk)] = true
+	}
+	chunks := split(t, shifted)
+	changed := 0
+	for _, chunk := range chunks {
+		if !seen[md5.Sum(chunk)] {
+			changed++
+		}
+	}
+	assert.LessOrEqual(t, changed, 1)
+}
+
+func TestEmptyInput(t *testing.T) {
+	assert.Empty(t, split(t, nil))
+}
@@ -10,7 +10,10 @@ import (
 )
 
 var origin, dest, originpass, destpass, originuser, destuser string
-var skip, compress bool
+var skip, compress, encrypt, resume, noxattrs, forcerehash bool
+var passwordfile, codec, description string
+var tags, xattrnamespaces []string
+var jobs int
 
 // backupCmd represents the backup command
 var backupCmd = &cobra.Command{
@@ -24,13 +27,23 @@ var backupCmd = &cobra.Command{
 		if error != nil {
 			log.Warn("Error building origin source:", error)
 		}
+		filter, error := BuildFilter()
+		if error != nil {
+			log.Fatal("Error building filter:", error)
+		}
+		originsource = sources.WithFilter(originsource, filter)
+		originsource = sources.WithXattrs(originsource, sources.Xattrs{Disabled: noxattrs, Namespaces: xattrnamespaces})
+		originsource = sources.WithHashCache(originsource, sources.DefaultHashCacheDir(), forcerehash)
 
 		destsource, error := BuildSource(dest, destpass, destuser)
 		if error != nil {
 			log.Warn("Error building origin source:", error)
 		}
 		log.Warn("compress mode is ", compress)
-		if error := handlers.Backup(originsource, destsource, sources.Setting{Compress: compress, Skip_hash: skip}); error != nil {
+		setting := sources.Setting{Compress: compress, Codec: codec, Skip_hash: skip, Encrypt: encrypt, Jobs: jobs, DryRun: dryrun,
+			Origin: sources.Location(origin), Tags: tags, Description: description, Resume: resume}
+		setting.Password = RepositoryPassword(destsource, encrypt)
+		if error := handlers.Backup(originsource, destsource, setting); error != nil {
 			log.Fatal("Error backing up:", error)
 		}
 
@@ -44,7 +57,20 @@ func init() {
 	// Here you will define your flags and configuration settings.
 	backupCmd.Flags().BoolVarP(&skip, "skip", "s", false, "Skip mode no check remote checksum")
 	backupCmd.Flags().BoolVar(&compress, "x", true, "Compress mode, compress files before sending to remote")
+	backupCmd.Flags().StringVar(&codec, "codec", "zstd", "compression codec and optional level: none, zstd[:1-22], gzip[:1-9], lz4[:0-9] or brotli[:0-11]")
+	backupCmd.Flags().BoolVar(&encrypt, "encrypt", false, "Encrypt a new destination with a repository password")
+
+	backupCmd.Flags().StringArrayVar(&tags, "tag", nil, "tag the snapshot (repeatable)")
+	backupCmd.Flags().StringVar(&description, "description", "", "free text describing the snapshot")
+	backupCmd.Flags().BoolVar(&resume, "resume", false, "continue the snapshot of an interrupted backup, skipping the files it holds")
+	backupCmd.Flags().BoolVar(&forcerehash, "force-rehash", false, "hash every file of the origin instead of reusing the cached hashes of the unchanged ones")
+
+	backupCmd.Flags().BoolVar(&noxattrs, "no-xattrs", false, "do not record the extended attributes, ACLs and SELinux labels of the files")
+	backupCmd.Flags().StringArrayVar(&xattrnamespaces, "xattr-namespace", nil, "only record the extended attributes of a namespace: user, security, system or trusted (repeatable)")
 
+	addFilterFlags(backupCmd)
+	backupCmd.Flags().BoolVarP(&dryrun, "dry-run", "n", false, "Only print what would be backed up")
+	backupCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Number of files transferred at the same time")
 	// Flags
 	backupCmd.Flags().StringVarP(&origin, "origin", "", "o", "origin: local or ssh (required)")
 	backupCmd.Flags().StringVarP(&dest, "dest", "", "o", "destination: local or ssh (required)")
@@ -61,6 +87,7 @@ func init() {
 
 	backupCmd.PersistentFlags().StringVar(&originuser, "origin-user", "", "SSH/DAV user (optional, will prompt if not provided)")
 	backupCmd.PersistentFlags().StringVar(&destuser, "dest-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+	backupCmd.PersistentFlags().StringVar(&passwordfile, "password-file", "", "file holding the repository password (optional, will use $CAPIVARA_PASSWORD or prompt)")
 
 	rootCmd.AddCommand(backupCmd)
 
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"uelei/capivara-sync/handlers"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var readdata bool
+var readsubset string
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Verify the snapshots of a destination can be restored",
+	Long: `Verify every block used by a snapshot is on the destination with the recorded hash.
+With --read-data (or --read-data-subset=10%) blocks are also downloaded and their content checked.
+Exits with a non zero code when blocks are missing or corrupt.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		percent := 0.0
+		if readdata {
+			percent = 100
+		}
+		if readsubset != "" {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(readsubset, "%"), 64)
+			if err != nil || value <= 0 || value > 100 {
+				log.Fatal("Invalid --read-data-subset, expected a percentage like 10%: ", readsubset)
+			}
+			percent = value
+		}
+
+		destsource, error := BuildSource(dest, destpass, destuser)
+		if error != nil {
+			log.Fatal("Error building destination source:", error)
+		}
+
+		setting := sources.Setting{Password: RepositoryPassword(destsource, false)}
+		result, error := handlers.Check(destsource, percent, setting)
+		if error != nil {
+			log.Fatal("Error checking repository:", error)
+		}
+
+		fmt.Println("Checked", result.Snapshots, "snapshots,", result.Files, "files,", result.Blocks, "blocks, read", result.Read, "blocks")
+		for _, name := range result.Missing {
+			fmt.Println("missing: ", name)
+		}
+		for _, name := range result.Corrupt {
+			fmt.Println("corrupt: ", name)
+		}
+		for _, name := range result.Orphaned {
+			fmt.Println("orphaned:", name)
+		}
+		fmt.Println("Missing:", len(result.Missing), "Corrupt:", len(result.Corrupt), "Orphaned:", len(result.Orphaned))
+		if len(result.Orphaned) > 0 {
+			fmt.Println("Orphaned blocks are not used by any snapshot, run prune to remove the ones recorded in the database")
+		}
+		if !result.Ok() {
+			os.Exit(1)
+		}
+		fmt.Println("No errors were found")
+	},
+}
+
+func init() {
+	checkCmd.Flags().BoolVar(&readdata, "read-data", false, "Download every block and verify its content")
+	checkCmd.Flags().StringVar(&readsubset, "read-data-subset", "", "Download a random percentage of the blocks, e.g. 10%")
+
+	checkCmd.Flags().StringVarP(&dest, "dest", "d", "", "destination: local or ssh (required)")
+	if error := checkCmd.MarkFlagRequired("dest"); error != nil {
+		log.Fatal("Error marking dest flag as required:", error)
+	}
+
+	checkCmd.PersistentFlags().StringVar(&destpass, "dest-password", "", "SSH/DAV password (optional, will prompt if not provided)")
+	checkCmd.PersistentFlags().StringVar(&destuser, "dest-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+	checkCmd.PersistentFlags().StringVar(&passwordfile, "password-file", "", "file holding the repository password (optional, will use $CAPIVARA_PASSWORD or prompt)")
+
+	rootCmd.AddCommand(checkCmd)
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"uelei/capivara-sync/config"
+
+	"github.com/spf13/cobra"
+)
+
+var configfile, profile string
+
+// noProfile is the annotation of the flags a profile does not set.
+const noProfile = "capivara_no_profile"
+
+// applyProfile sets the flags of the command not given on the command line
+// from the --profile of the configuration file.
+func applyProfile(cmd *cobra.Command, args []string) error {
+	if profile == "" {
+		return nil
+	}
+	path := configfile
+	if path == "" {
+		path = config.DefaultPath()
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return fmt.Errorf("error loading configuration: %w", err)
+	}
+	p, err := cfg.GetProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	for name, values := range profileFlags(p) {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed || flag.Annotations[noProfile] != nil {
+			continue
+		}
+		for _, value := range values {
+			if err := cmd.Flags().Set(name, value); err != nil {
+				return fmt.Errorf("profile %s: invalid %s: %w", profile, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// profileFlags maps the flag names to the values a profile gives them,
+// options the profile leaves empty are not set.
+func profileFlags(p config.Profile) map[string][]string {
+	flags := map[string][]string{}
+	set := func(name string, value string) {
+		if value != "" {
+			flags[name] = []string{value}
+		}
+	}
+	count := func(name string, value int) {
+		if value > 0 {
+			set(name, strconv.Itoa(value))
+		}
+	}
+
+	set("origin", p.Origin)
+	set("origin-user", p.OriginUser)
+	set("origin-password", p.OriginPassword)
+	set("dest", p.Dest)
+	set("dest-user", p.DestUser)
+	set("dest-password", p.DestPassword)
+	set("password-file", p.PasswordFile)
+	if p.Encrypt {
+		set("encrypt", "true")
+	}
+
+	flags["exclude"] = p.Exclude
+	flags["include"] = p.Include
+	flags["exclude-from"] = p.ExcludeFrom
+	set("min-size", p.MinSize)
+	set("max-size", p.MaxSize)
+	set("newer-than", p.NewerThan)
+	set("older-than", p.OlderThan)
+
+	flags["tag"] = p.Tags
+	set("codec", p.Compression)
+	count("jobs", p.Jobs)
+	count("keep-last", p.Keep.Last)
+	count("keep-daily", p.Keep.Daily)
+	count("keep-weekly", p.Keep.Weekly)
+	count("keep-monthly", p.Keep.Monthly)
+	count("keep-yearly", p.Keep.Yearly)
+	return flags
+}
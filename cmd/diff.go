@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"uelei/capivara-sync/handlers"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var live, asjson bool
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <snapshot> [snapshot]",
+	Short: "Show what changed between two snapshots",
+	Long: `Show the files added, removed, modified and the ones with only their mode or mtime changed
+between two snapshots, with the size of each change. Snapshots are given by id, by date as restore --snap
+takes it, or as "latest". With --live the snapshot is compared to the files of the --origin now.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if live {
+			return cobra.ExactArgs(1)(cmd, args)
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+
+		destsource, error := BuildSource(dest, destpass, destuser)
+		if error != nil {
+			log.Fatal("Error building destination source:", error)
+		}
+		setting := sources.Setting{Password: RepositoryPassword(destsource, false)}
+
+		if !live {
+			if error := handlers.Diff(destsource, args[0], args[1], asjson, setting); error != nil {
+				log.Fatal("Error comparing snapshots:", error)
+			}
+			return
+		}
+
+		if origin == "" {
+			log.Fatal("--live needs the --origin to compare the snapshot to")
+		}
+		originsource, error := BuildSource(origin, originpass, originuser)
+		if error != nil {
+			log.Fatal("Error building origin source:", error)
+		}
+		filter, error := BuildFilter()
+		if error != nil {
+			log.Fatal("Error building filter:", error)
+		}
+		originsource = sources.WithFilter(originsource, filter)
+		if error := handlers.DiffLive(originsource, destsource, args[0], asjson, setting); error != nil {
+			log.Fatal("Error comparing snapshot to origin:", error)
+		}
+	},
+}
+
+func init() {
+	diffCmd.Flags().BoolVar(&live, "live", false, "compare the snapshot to the current files of the origin")
+	diffCmd.Flags().BoolVar(&asjson, "json", false, "print the changes as JSON")
+	addFilterFlags(diffCmd)
+
+	diffCmd.Flags().StringVarP(&origin, "origin", "o", "", "origin: local or ssh, with --live")
+	diffCmd.Flags().StringVarP(&dest, "dest", "d", "", "destination: local or ssh (required)")
+	if error := diffCmd.MarkFlagRequired("dest"); error != nil {
+		log.Fatal("Error marking dest flag as required:", error)
+	}
+
+	diffCmd.PersistentFlags().StringVar(&originpass, "origin-password", "", "SSH/DAV password (optional, will prompt if not provided)")
+	diffCmd.PersistentFlags().StringVar(&destpass, "dest-password", "", "SSH/DAV password (optional, will prompt if not provided)")
+	diffCmd.PersistentFlags().StringVar(&originuser, "origin-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+	diffCmd.PersistentFlags().StringVar(&destuser, "dest-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+	diffCmd.PersistentFlags().StringVar(&passwordfile, "password-file", "", "file holding the repository password (optional, will use $CAPIVARA_PASSWORD or prompt)")
+
+	rootCmd.AddCommand(diffCmd)
+}
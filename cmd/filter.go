@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"time"
+	"uelei/capivara-sync/sources"
+
+	"github.com/spf13/cobra"
+)
+
+var excludes, includes, excludefrom []string
+var minsize, maxsize, newerthan, olderthan string
+
+// addFilterFlags adds the flags choosing which files of the origin are read.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&includes, "include", nil, "include again files an exclude pattern matched (repeatable)")
+	addExcludeFlags(cmd)
+}
+
+// addExcludeFlags adds the filter flags but --include, which restore uses
+// for its own selection.
+func addExcludeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&excludes, "exclude", nil, "exclude files matching a .gitignore style pattern (repeatable)")
+	cmd.Flags().StringArrayVar(&excludefrom, "exclude-from", nil, "read exclude patterns from a file, one per line (repeatable)")
+	cmd.Flags().StringVar(&minsize, "min-size", "", "skip files smaller than this size, like 10K")
+	cmd.Flags().StringVar(&maxsize, "max-size", "", "skip files larger than this size, like 1G")
+	cmd.Flags().StringVar(&newerthan, "newer-than", "", "only files modified within this age, like 7d or 12h")
+	cmd.Flags().StringVar(&olderthan, "older-than", "", "only files modified before this age, like 30d")
+}
+
+// BuildFilter returns the filter given by the filter flags, the patterns of
+// the --exclude-from files come before the --exclude and --include ones.
+func BuildFilter() (*sources.Filter, error) {
+	filter := &sources.Filter{}
+	for _, filename := range excludefrom {
+		if err := filter.ExcludeFrom(filename); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range excludes {
+		if err := filter.Exclude(pattern); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range includes {
+		if err := filter.Exclude("!" + pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if minsize != "" {
+		if filter.MinSize, err = sources.ParseSize(minsize); err != nil {
+			return nil, err
+		}
+	}
+	if maxsize != "" {
+		if filter.MaxSize, err = sources.ParseSize(maxsize); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	if newerthan != "" {
+		age, err := sources.ParseAge(newerthan)
+		if err != nil {
+			return nil, err
+		}
+		filter.NewerThan = now.Add(-age)
+	}
+	if olderthan != "" {
+		age, err := sources.ParseAge(olderthan)
+		if err != nil {
+			return nil, err
+		}
+		filter.OlderThan = now.Add(-age)
+	}
+	return filter, nil
+}
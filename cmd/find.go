@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"uelei/capivara-sync/handlers"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// findCmd represents the find command
+var findCmd = &cobra.Command{
+	Use:   "find <pattern>",
+	Short: "Find the snapshots holding files matching a pattern",
+	Long: `Search every snapshot for the paths matching a glob pattern and show which snapshots hold them
+and how many versions of their content there are. A pattern without a slash matches the file name.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		destsource, error := BuildSource(dest, destpass, destuser)
+		if error != nil {
+			log.Fatal("Error building destination source:", error)
+		}
+		setting := sources.Setting{Password: RepositoryPassword(destsource, false)}
+		if error := handlers.Find(destsource, args[0], asjson, setting); error != nil {
+			log.Fatal("Error searching snapshots:", error)
+		}
+	},
+}
+
+func init() {
+	findCmd.Flags().BoolVar(&asjson, "json", false, "print the paths and their snapshots as JSON")
+
+	findCmd.Flags().StringVarP(&dest, "dest", "d", "", "destination: local or ssh (required)")
+	if error := findCmd.MarkFlagRequired("dest"); error != nil {
+		log.Fatal("Error marking dest flag as required:", error)
+	}
+
+	findCmd.PersistentFlags().StringVar(&destpass, "dest-password", "", "SSH/DAV password (optional, will prompt if not provided)")
+	findCmd.PersistentFlags().StringVar(&destuser, "dest-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+	findCmd.PersistentFlags().StringVar(&passwordfile, "password-file", "", "file holding the repository password (optional, will use $CAPIVARA_PASSWORD or prompt)")
+
+	rootCmd.AddCommand(findCmd)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"uelei/capivara-sync/handlers"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var policy handlers.RetentionPolicy
+var prune, dryrun bool
+
+// forgetCmd represents the forget command
+var forgetCmd = &cobra.Command{
+	Use:   "forget",
+	Short: "Remove snapshots not kept by a retention policy",
+	Long: `Remove the snapshots not kept by the --keep-* retention policy from a destination.
+With --prune the blocks no remaining snapshot uses are removed too.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		destsource, error := BuildSource(dest, destpass, destuser)
+		if error != nil {
+			log.Fatal("Error building destination source:", error)
+		}
+
+		setting := sources.Setting{Password: RepositoryPassword(destsource, false)}
+		if error := handlers.Forget(destsource, policy, prune, dryrun, setting); error != nil {
+			log.Fatal("Error forgetting snapshots:", error)
+		}
+	},
+}
+
+func init() {
+	forgetCmd.Flags().IntVar(&policy.Last, "keep-last", 0, "keep the last n snapshots")
+	forgetCmd.Flags().IntVar(&policy.Daily, "keep-daily", 0, "keep the last snapshot of the last n days")
+	forgetCmd.Flags().IntVar(&policy.Weekly, "keep-weekly", 0, "keep the last snapshot of the last n weeks")
+	forgetCmd.Flags().IntVar(&policy.Monthly, "keep-monthly", 0, "keep the last snapshot of the last n months")
+	forgetCmd.Flags().IntVar(&policy.Yearly, "keep-yearly", 0, "keep the last snapshot of the last n years")
+	forgetCmd.Flags().BoolVar(&prune, "prune", false, "Remove the blocks no longer used by any snapshot")
+	forgetCmd.Flags().BoolVarP(&dryrun, "dry-run", "n", false, "Only report what would be removed")
+
+	forgetCmd.Flags().StringVarP(&dest, "dest", "d", "", "destination: local or ssh (required)")
+	if error := forgetCmd.MarkFlagRequired("dest"); error != nil {
+		log.Fatal("Error marking dest flag as required:", error)
+	}
+
+	forgetCmd.PersistentFlags().StringVar(&destpass, "dest-password", "", "SSH/DAV password (optional, will prompt if not provided)")
+	forgetCmd.PersistentFlags().StringVar(&destuser, "dest-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+	forgetCmd.PersistentFlags().StringVar(&passwordfile, "password-file", "", "file holding the repository password (optional, will use $CAPIVARA_PASSWORD or prompt)")
+
+	rootCmd.AddCommand(forgetCmd)
+}
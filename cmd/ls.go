@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"uelei/capivara-sync/handlers"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var long bool
+var globs []string
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls <snapshot> [path]",
+	Short: "List the files of a snapshot",
+	Long: `List the files a snapshot holds under a path as a directory tree with their sizes, without
+restoring them. The snapshot is given by id, by date as restore --snap takes it, or as "latest".
+--glob keeps the files matching a pattern, a pattern without a slash matches the file name.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		dir := ""
+		if len(args) > 1 {
+			dir = args[1]
+		}
+		destsource, error := BuildSource(dest, destpass, destuser)
+		if error != nil {
+			log.Fatal("Error building destination source:", error)
+		}
+		setting := sources.Setting{Password: RepositoryPassword(destsource, false)}
+		if error := handlers.Ls(destsource, args[0], dir, globs, long, asjson, setting); error != nil {
+			log.Fatal("Error listing snapshot:", error)
+		}
+	},
+}
+
+func init() {
+	lsCmd.Flags().StringArrayVar(&globs, "glob", nil, "only the files matching a glob pattern like *.jpg (repeatable)")
+	lsCmd.Flags().BoolVarP(&long, "long", "l", false, "also show the mode and modification time")
+	lsCmd.Flags().BoolVar(&asjson, "json", false, "print the files as JSON")
+
+	lsCmd.Flags().StringVarP(&dest, "dest", "d", "", "destination: local or ssh (required)")
+	if error := lsCmd.MarkFlagRequired("dest"); error != nil {
+		log.Fatal("Error marking dest flag as required:", error)
+	}
+
+	lsCmd.PersistentFlags().StringVar(&destpass, "dest-password", "", "SSH/DAV password (optional, will prompt if not provided)")
+	lsCmd.PersistentFlags().StringVar(&destuser, "dest-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+	lsCmd.PersistentFlags().StringVar(&passwordfile, "password-file", "", "file holding the repository password (optional, will use $CAPIVARA_PASSWORD or prompt)")
+
+	rootCmd.AddCommand(lsCmd)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"uelei/capivara-sync/handlers"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove blocks no snapshot uses anymore",
+	Long:  `Remove from a destination the blocks left behind by forgotten snapshots.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		destsource, error := BuildSource(dest, destpass, destuser)
+		if error != nil {
+			log.Fatal("Error building destination source:", error)
+		}
+
+		setting := sources.Setting{Password: RepositoryPassword(destsource, false)}
+		if error := handlers.Prune(destsource, dryrun, setting); error != nil {
+			log.Fatal("Error pruning blocks:", error)
+		}
+	},
+}
+
+func init() {
+	pruneCmd.Flags().BoolVarP(&dryrun, "dry-run", "n", false, "Only report what would be removed")
+
+	pruneCmd.Flags().StringVarP(&dest, "dest", "d", "", "destination: local or ssh (required)")
+	if error := pruneCmd.MarkFlagRequired("dest"); error != nil {
+		log.Fatal("Error marking dest flag as required:", error)
+	}
+
+	pruneCmd.PersistentFlags().StringVar(&destpass, "dest-password", "", "SSH/DAV password (optional, will prompt if not provided)")
+	pruneCmd.PersistentFlags().StringVar(&destuser, "dest-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+	pruneCmd.PersistentFlags().StringVar(&passwordfile, "password-file", "", "file holding the repository password (optional, will use $CAPIVARA_PASSWORD or prompt)")
+
+	rootCmd.AddCommand(pruneCmd)
+}
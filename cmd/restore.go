@@ -4,16 +4,19 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"os"
 	"uelei/capivara-sync/db"
 	"uelei/capivara-sync/handlers"
+	"uelei/capivara-sync/sources"
 )
 
 var list, clean bool
-var snap string
+var snap, host, snappath, originpath, target, stripprefix string
+var restoreincludes []string
+var trash string
+var notrash, yes, numericowner bool
+var cleanlimit int
 
 // restoreCmd represents the restore command
 var restoreCmd = &cobra.Command{
@@ -28,52 +31,53 @@ var restoreCmd = &cobra.Command{
 		}
 
 		if list {
-			database, er := handlers.GetDatabaseFromRemote(destsource)
-			if er != nil {
-				log.Error("Error getting database from remote:", er)
+			setting := sources.Setting{Password: RepositoryPassword(destsource, false)}
+			filter := db.SnapshotFilter{Tags: tags, Host: host}
+			// --path is the origin filter of the listing, like --origin-path
+			if originpath == "" {
+				originpath = snappath
 			}
-
-			defer func() {
-				log.Info("Clean up environment")
-				if err := database.Close(); err != nil {
-					log.Fatal("Error closing database:", err)
-				}
-
-				db_file, _ := os.ReadFile("snapshot_files.db")
-				// Move the database file to another folder
-				log.Info("Saving database file to remote storage")
-				saveerr := destsource.SaveFile("snapshot_files.db", db_file, "-rw-r--r--")
-				if saveerr != nil {
-					log.Fatal("Error saving database file to local storage:", saveerr)
-				} else {
-					// newPath := "new_folder/snapshot_files.db"
-					if err := os.Remove("snapshot_files.db"); err != nil {
-						log.Error("Error removing local database file:", err)
-					}
-					// err := os.Rename("snapshot_files.db", newPath)
-					// if err != nil {
-					// 	log.Fatalf("Failed to move database file: %v", err)
-				}
-			}()
-
-			fmt.Println("Listing snapshots")
-
-			snaps, err := db.ListSnapShots(database)
-			if err != nil {
-				log.Fatal("Error listing snapshots:", err)
+			if originpath != "" {
+				filter.Path = sources.Location(originpath)
 			}
-			for _, snp := range snaps {
-				fmt.Println("Snapshot ID:", snp.Id, "Date:", snp.Date)
+			if err := handlers.ListSnapshots(destsource, filter, setting); err != nil {
+				log.Fatal("Error listing snapshots:", err)
 			}
 
 		} else {
-			originsource, error := BuildSource(origin, originpass, originuser)
+			// --target restores somewhere else than the origin
+			restoreto := origin
+			if target != "" {
+				restoreto = target
+			}
+			if restoreto == "" {
+				log.Fatal("Give the --origin or the --target to restore to")
+			}
+			originsource, error := BuildSource(restoreto, originpass, originuser)
 			if error != nil {
 				log.Warn("Error building origin source:", error)
 			}
+			if clean {
+				// the files the backup filter left out are not in the snapshot
+				// and are kept by the clean
+				filter, error := BuildFilter()
+				if error != nil {
+					log.Fatal("Error building filter:", error)
+				}
+				originsource = sources.WithFilter(originsource, filter)
+			}
 
 			// starting the handler
-			if err := handlers.Restore(originsource, destsource, snap, clean); err != nil {
+			setting := sources.Setting{Password: RepositoryPassword(destsource, false), Jobs: jobs, DryRun: dryrun,
+				RestorePath: snappath, Include: restoreincludes, StripPrefix: stripprefix,
+				Trash: trash, CleanLimit: cleanlimit, Prompt: terminalPrompt, NumericOwner: numericowner, NoXattrs: noxattrs}
+			if notrash {
+				setting.Trash = ""
+			}
+			if yes {
+				setting.CleanLimit = 0
+			}
+			if err := handlers.Restore(originsource, destsource, snap, clean, setting); err != nil {
 				log.Fatal("Error restoring snapshot:", err)
 			}
 		}
@@ -83,9 +87,29 @@ var restoreCmd = &cobra.Command{
 
 func init() {
 	restoreCmd.Flags().BoolVarP(&list, "list", "l", false, "List snapshots dates")
-	restoreCmd.Flags().BoolVarP(&clean, "clean", "c", false, "List snapshots dates")
-
-	restoreCmd.Flags().StringVarP(&origin, "origin", "o", "", "origin: local or ssh (required)")
+	restoreCmd.Flags().StringArrayVar(&tags, "tag", nil, "with --list, only the snapshots with this tag (repeatable)")
+	restoreCmd.Flags().StringVar(&host, "host", "", "with --list, only the snapshots taken on this host")
+	restoreCmd.Flags().StringVar(&originpath, "origin-path", "", "with --list, only the snapshots of this origin path or URL")
+	restoreCmd.Flags().StringVar(&snappath, "path", "", "restore only this file or directory of the snapshot, with --list the same as --origin-path")
+	restoreCmd.Flags().StringArrayVar(&restoreincludes, "include", nil, "restore only the files matching a glob pattern like *.jpg (repeatable)")
+	restoreCmd.Flags().StringVar(&stripprefix, "strip-prefix", "", "remove this directory from the restored paths, the files outside it are not restored")
+	restoreCmd.Flags().StringVar(&target, "target", "", "restore to this local path or URL instead of the origin")
+	// the include patterns of a profile are backup filters, not a restore selection
+	if err := restoreCmd.Flags().SetAnnotation("include", noProfile, []string{"true"}); err != nil {
+		log.Fatal(err)
+	}
+	restoreCmd.Flags().BoolVarP(&clean, "clean", "c", false, "Remove the files of the origin the snapshot does not have, keeping the ones the filter flags and .capivaraignore files exclude")
+	addExcludeFlags(restoreCmd)
+	restoreCmd.Flags().StringVar(&trash, "trash", handlers.DefaultTrash, "with --clean, directory of the origin the removed files are moved to")
+	restoreCmd.Flags().BoolVar(&notrash, "no-trash", false, "with --clean, delete the removed files instead of moving them to the trash")
+	restoreCmd.Flags().IntVar(&cleanlimit, "clean-limit", 100, "with --clean, ask before removing more files than this, 0 never asks")
+	restoreCmd.Flags().BoolVarP(&yes, "yes", "y", false, "with --clean, remove the files without asking")
+	restoreCmd.Flags().BoolVar(&numericowner, "numeric-owner", false, "when restoring as root, use the recorded owner ids instead of the owner names")
+	restoreCmd.Flags().BoolVar(&noxattrs, "no-xattrs", false, "do not restore the extended attributes, ACLs and SELinux labels of the files")
+
+	restoreCmd.Flags().BoolVarP(&dryrun, "dry-run", "n", false, "Only print what would be restored and removed")
+	restoreCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Number of files transferred at the same time")
+	restoreCmd.Flags().StringVarP(&origin, "origin", "o", "", "origin: local or ssh, where the files are restored unless --target is given")
 	restoreCmd.Flags().StringVarP(&dest, "dest", "d", "", "destination: local or ssh (required)")
 
 	restoreCmd.Flags().StringVarP(&snap, "snap", "s", "", "snap date to restore if not latest (optional)")
@@ -99,6 +123,7 @@ func init() {
 
 	restoreCmd.PersistentFlags().StringVar(&originuser, "origin-user", "", "SSH/DAV user (optional, will prompt if not provided)")
 	restoreCmd.PersistentFlags().StringVar(&destuser, "dest-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+	restoreCmd.PersistentFlags().StringVar(&passwordfile, "password-file", "", "file holding the repository password (optional, will use $CAPIVARA_PASSWORD or prompt)")
 	rootCmd.AddCommand(restoreCmd)
 
 }
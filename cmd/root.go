@@ -14,6 +14,8 @@ var rootCmd = &cobra.Command{
 	Short: "capivara-sync is a backup tool for your files ",
 	Long: `capivara-sync is a backup tool that use zts to reduce remote storage use,
 	It can restore to a point in time.`,
+	PersistentPreRunE: applyProfile,
+	PersistentPostRun: closeSources,
 }
 
 func Execute() {
@@ -24,4 +26,6 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&configfile, "config", "", "configuration file (default ~/.config/capivara-sync/config.yaml)")
+	rootCmd.PersistentFlags().StringVarP(&profile, "profile", "p", "", "profile of the configuration file giving the flags not on the command line")
 }
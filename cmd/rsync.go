@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"uelei/capivara-sync/handlers"
+	"uelei/capivara-sync/sources"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -22,13 +23,18 @@ var syncCmd = &cobra.Command{
 		if error != nil {
 			log.Warn("Error building origin source:", error)
 		}
+		filter, error := BuildFilter()
+		if error != nil {
+			log.Fatal("Error building filter:", error)
+		}
+		originsource = sources.WithFilter(originsource, filter)
 
 		destsource, error := BuildSource(dest, destpass, destuser)
 		if error != nil {
 			log.Warn("Error building origin source:", error)
 		}
 		log.Warn("compress mode is ", compress)
-		if error := handlers.RSync(originsource, destsource, delete); error != nil {
+		if error := handlers.RSync(originsource, destsource, delete, sources.Setting{Jobs: jobs, DryRun: dryrun}); error != nil {
 			log.Fatal("Error backing up:", error)
 		}
 
@@ -39,6 +45,9 @@ var syncCmd = &cobra.Command{
 func init() {
 
 	syncCmd.Flags().BoolVarP(&delete, "delete", "d", false, "Delete files on destination if not on the origin")
+	addFilterFlags(syncCmd)
+	syncCmd.Flags().BoolVarP(&dryrun, "dry-run", "n", false, "Only print what would be synced and deleted")
+	syncCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Number of files transferred at the same time")
 	// Flags
 	syncCmd.Flags().StringVarP(&origin, "origin", "", "o", "origin: local or ssh (required)")
 	syncCmd.Flags().StringVarP(&dest, "dest", "", "o", "destination: local or ssh (required)")
@@ -1,73 +1,95 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/ssh"
+	"github.com/spf13/cobra"
 	"golang.org/x/term"
+	"io"
+	"os"
 	"strings"
 	"syscall"
+	"uelei/capivara-sync/handlers"
 	"uelei/capivara-sync/sources"
 )
 
-func EnsureTrailingSlash(path string) string {
-	if !strings.HasSuffix(path, "/") {
-		return path + "/"
+// BuildSource opens a source from a URL of a registered scheme, an SSH
+// user@host:path target or a local path.
+func BuildSource(source_path string, password string, user string) (sources.Source, error) {
+	source, err := sources.Open(source_path, sources.Options{
+		User:     user,
+		Password: password,
+		Prompt:   terminalPrompt,
+	})
+	if closer, ok := source.(io.Closer); ok && err == nil {
+		opened = append(opened, closer)
 	}
-	return path
+	return source, err
 }
 
-func BuildSource(source_path string, password string, user string) (sources.Source, error) {
-
-	if strings.Contains(source_path, "http") {
-		host := ""
-		if password == "" {
-			fmt.Print("Enter password: ")
-			bytePassword, err := term.ReadPassword(int(syscall.Stdin))
-			if err != nil {
-				log.Fatal(err)
-			}
-			fmt.Println() // for newline
-			password = string(bytePassword)
+// opened holds the sources with a connection to close once the command ran.
+var opened []io.Closer
 
+func closeSources(cmd *cobra.Command, args []string) {
+	for _, closer := range opened {
+		if err := closer.Close(); err != nil {
+			log.Warn("Error closing source: ", err)
 		}
-		if strings.Contains(source_path, "@") && user == "" {
-
-			sourceParts := strings.Split(source_path, "@")
-
-			user = sourceParts[0]
-			host = sourceParts[1]
+	}
+	opened = nil
+}
 
-		} else {
-			if user == "" {
-				log.Fatal("User not provided for SSH/DAV source")
-			}
-			host = source_path
+// RepositoryPassword returns the password of an encrypted destination, or of
+// the one about to be created, from --password-file, $CAPIVARA_PASSWORD or a
+// prompt. It is empty for unencrypted destinations.
+func RepositoryPassword(destination sources.Source, create bool) string {
+	if !create && !destination.Exists(handlers.KeyFileName) {
+		return ""
+	}
 
+	if passwordfile != "" {
+		data, err := os.ReadFile(passwordfile)
+		if err != nil {
+			log.Fatal("Error reading password file:", err)
 		}
-
-		return sources.NewWebDAVSource(host, user, password)
-
+		return strings.TrimRight(string(data), "\r\n")
+	}
+	if password := os.Getenv("CAPIVARA_PASSWORD"); password != "" {
+		return password
 	}
 
-	if strings.Contains(source_path, "@") {
-
-		if password == "" {
-			fmt.Print("Enter password: ")
-			bytePassword, err := term.ReadPassword(int(syscall.Stdin))
-			if err != nil {
-				log.Fatal(err)
-			}
-			fmt.Println() // for newline
-			password = string(bytePassword)
-
+	fmt.Print("Enter repository password: ")
+	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println() // for newline
+	if create && !destination.Exists(handlers.KeyFileName) {
+		fmt.Print("Confirm repository password: ")
+		confirm, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println()
+		if string(confirm) != string(bytePassword) {
+			log.Fatal("Passwords do not match")
 		}
-		auth := ssh.Password(password)
-		originParts := strings.Split(source_path, "@")
-		hostpaths := strings.Split(originParts[1], ":")
-		originPath := EnsureTrailingSlash(hostpaths[1])
-		return sources.NewSSHSource(originParts[0], hostpaths[0]+":22", originPath, auth)
 	}
+	return string(bytePassword)
+}
 
-	return sources.Localsource{Localpath: EnsureTrailingSlash(source_path)}, nil
+// terminalPrompt asks on the terminal, secrets are read without echo.
+func terminalPrompt(question string, secret bool) (string, error) {
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return "", sources.ErrNoPrompt
+	}
+	fmt.Print(question)
+	if secret {
+		answer, err := term.ReadPassword(int(syscall.Stdin))
+		fmt.Println() // for newline
+		return string(answer), err
+	}
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(answer, "\r\n"), err
 }
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"uelei/capivara-sync/handlers"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var force bool
+
+// unlockCmd represents the unlock command
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Remove a stale lock from a destination",
+	Long: `Remove the lock left on a destination by a backup that did not finish.
+The lock and the reader locks of restore and check are only removed when their owner is no longer
+running, unless --force is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		destsource, error := BuildSource(dest, destpass, destuser)
+		if error != nil {
+			log.Fatal("Error building destination source:", error)
+		}
+
+		if error := handlers.Unlock(destsource, force); error != nil {
+			log.Fatal("Error unlocking repository:", error)
+		}
+	},
+}
+
+func init() {
+	unlockCmd.Flags().BoolVarP(&force, "force", "f", false, "Remove the lock even if its owner may still be running")
+	unlockCmd.Flags().StringVarP(&dest, "dest", "d", "", "destination: local or ssh (required)")
+
+	if error := unlockCmd.MarkFlagRequired("dest"); error != nil {
+		log.Fatal("Error marking dest flag as required:", error)
+	}
+
+	unlockCmd.PersistentFlags().StringVar(&destpass, "dest-password", "", "SSH/DAV password (optional, will prompt if not provided)")
+	unlockCmd.PersistentFlags().StringVar(&destuser, "dest-user", "", "SSH/DAV user (optional, will prompt if not provided)")
+
+	rootCmd.AddCommand(unlockCmd)
+}
@@ -0,0 +1,197 @@
+package compressor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codec compresses blocks. The name is what the database records for every
+// block, the level only matters when compressing so it is not part of it.
+type Codec interface {
+	Name() string
+	// Extension is appended to the names of the blocks stored with the codec.
+	Extension() string
+	Compress(data []byte) ([]byte, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Codec names.
+const (
+	None   = "none"
+	Zstd   = "zstd"
+	Gzip   = "gzip"
+	Lz4    = "lz4"
+	Brotli = "brotli"
+)
+
+// ParseCodec returns the codec of a spec like "zstd", "zstd:19" or "none",
+// the number after the colon is the compression level. An empty spec is zstd.
+func ParseCodec(spec string) (Codec, error) {
+	name, levelStr, hasLevel := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), ":")
+	level := 0
+	if hasLevel {
+		var err error
+		if level, err = strconv.Atoi(levelStr); err != nil {
+			return nil, fmt.Errorf("invalid compression level %q", levelStr)
+		}
+	}
+
+	switch name {
+	case "", Zstd:
+		if !hasLevel {
+			level = 3
+		}
+		if level < 1 || level > 22 {
+			return nil, fmt.Errorf("zstd level must be between 1 and 22, got %d", level)
+		}
+		return zstdCodec{level: zstd.EncoderLevelFromZstd(level)}, nil
+	case None:
+		return noneCodec{}, nil
+	case Gzip:
+		if !hasLevel {
+			level = 6
+		}
+		if level < gzip.BestSpeed || level > gzip.BestCompression {
+			return nil, fmt.Errorf("gzip level must be between 1 and 9, got %d", level)
+		}
+		return gzipCodec{level: level}, nil
+	case Lz4:
+		if level < 0 || level > 9 {
+			return nil, fmt.Errorf("lz4 level must be between 0 and 9, got %d", level)
+		}
+		compression := lz4.Fast
+		if level > 0 {
+			compression = lz4.CompressionLevel(1 << (8 + level))
+		}
+		return lz4Codec{level: compression}, nil
+	case Brotli:
+		if !hasLevel {
+			level = brotli.DefaultCompression
+		}
+		if level < brotli.BestSpeed || level > brotli.BestCompression {
+			return nil, fmt.Errorf("brotli level must be between 0 and 11, got %d", level)
+		}
+		return brotliCodec{level: level}, nil
+	}
+	return nil, fmt.Errorf("unknown compression codec %q, use none, zstd, gzip, lz4 or brotli", name)
+}
+
+// GetCodec returns the codec recorded for a block, at its default level.
+func GetCodec(name string) (Codec, error) {
+	if strings.Contains(name, ":") {
+		return nil, fmt.Errorf("invalid codec name %q", name)
+	}
+	return ParseCodec(name)
+}
+
+// compressWith runs data through a compressing writer.
+func compressWith(data []byte, newWriter func(io.Writer) (io.WriteCloser, error)) ([]byte, error) {
+	var out bytes.Buffer
+	writer, err := newWriter(&out)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Uncompressed stores the data as is.
+var Uncompressed Codec = noneCodec{}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string                         { return None }
+func (noneCodec) Extension() string                    { return "" }
+func (noneCodec) Compress(data []byte) ([]byte, error) { return data, nil }
+func (noneCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type zstdCodec struct {
+	level zstd.EncoderLevel
+}
+
+func (zstdCodec) Name() string      { return Zstd }
+func (zstdCodec) Extension() string { return ".zst" }
+
+func (c zstdCodec) Compress(data []byte) ([]byte, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(c.level))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll(data, nil), nil
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return NewZstdReader(r)
+}
+
+type gzipCodec struct {
+	level int
+}
+
+func (gzipCodec) Name() string      { return Gzip }
+func (gzipCodec) Extension() string { return ".gz" }
+
+func (c gzipCodec) Compress(data []byte) ([]byte, error) {
+	return compressWith(data, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, c.level)
+	})
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type lz4Codec struct {
+	level lz4.CompressionLevel
+}
+
+func (lz4Codec) Name() string      { return Lz4 }
+func (lz4Codec) Extension() string { return ".lz4" }
+
+func (c lz4Codec) Compress(data []byte) ([]byte, error) {
+	return compressWith(data, func(w io.Writer) (io.WriteCloser, error) {
+		writer := lz4.NewWriter(w)
+		if err := writer.Apply(lz4.CompressionLevelOption(c.level)); err != nil {
+			return nil, err
+		}
+		return writer, nil
+	})
+}
+
+func (lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(lz4.NewReader(r)), nil
+}
+
+type brotliCodec struct {
+	level int
+}
+
+func (brotliCodec) Name() string      { return Brotli }
+func (brotliCodec) Extension() string { return ".br" }
+
+func (c brotliCodec) Compress(data []byte) ([]byte, error) {
+	return compressWith(data, func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(w, c.level), nil
+	})
+}
+
+func (brotliCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
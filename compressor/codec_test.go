@@ -0,0 +1,43 @@
+package compressor
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecsRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("capivara sync compresses blocks "), 4096)
+	for _, spec := range []string{"none", "zstd", "zstd:19", "gzip", "gzip:1", "lz4", "lz4:9", "brotli", "brotli:11", ""} {
+		codec, err := ParseCodec(spec)
+		require.NoError(t, err, spec)
+
+		compressed, err := codec.Compress(data)
+		require.NoError(t, err, spec)
+		if codec.Name() != None {
+			assert.Less(t, len(compressed), len(data), spec)
+		}
+
+		// blocks are read back with the codec recorded by name
+		recorded, err := GetCodec(codec.Name())
+		require.NoError(t, err, spec)
+		reader, err := recorded.NewReader(bytes.NewReader(compressed))
+		require.NoError(t, err, spec)
+		decompressed, err := io.ReadAll(reader)
+		require.NoError(t, err, spec)
+		assert.NoError(t, reader.Close())
+		assert.Equal(t, data, decompressed, spec)
+	}
+}
+
+func TestParseCodecErrors(t *testing.T) {
+	for _, spec := range []string{"xz", "zstd:0", "zstd:23", "gzip:10", "lz4:10", "brotli:12", "zstd:fast"} {
+		_, err := ParseCodec(spec)
+		assert.Error(t, err, spec)
+	}
+	_, err := GetCodec("zstd:19")
+	assert.Error(t, err)
+}
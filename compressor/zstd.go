@@ -3,6 +3,7 @@ package compressor
 import (
 	"fmt"
 	"github.com/klauspost/compress/zstd"
+	"io"
 )
 
 // compressZstd compresses a []byte using zstd
@@ -28,3 +29,22 @@ func DecompressZstd(compressed []byte) ([]byte, error) {
 	defer decoder.Close()
 	return decoder.DecodeAll(compressed, nil)
 }
+
+// NewZstdWriter compresses everything written to it into w, Close flushes the
+// frame but does not close w.
+func NewZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+	}
+	return encoder, nil
+}
+
+// NewZstdReader decompresses the zstd stream read from r.
+func NewZstdReader(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %v", err)
+	}
+	return decoder.IOReadCloser(), nil
+}
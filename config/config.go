@@ -0,0 +1,163 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the content of the configuration file: destinations shared by
+// several profiles, and the profiles naming what a command works on.
+type Config struct {
+	Repositories map[string]Repository `yaml:"repositories"`
+	Profiles     map[string]Profile    `yaml:"profiles"`
+}
+
+// Repository is a destination and the credentials to reach it.
+type Repository struct {
+	URL      string `yaml:"url"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// PasswordFile holds the password of an encrypted repository.
+	PasswordFile string `yaml:"password-file"`
+	Encrypt      bool   `yaml:"encrypt"`
+}
+
+// Profile holds the options of the commands run with --profile, its
+// destination is a named repository or given by Dest.
+type Profile struct {
+	Origin         string `yaml:"origin"`
+	OriginUser     string `yaml:"origin-user"`
+	OriginPassword string `yaml:"origin-password"`
+
+	Repository   string `yaml:"repository"`
+	Dest         string `yaml:"dest"`
+	DestUser     string `yaml:"dest-user"`
+	DestPassword string `yaml:"dest-password"`
+	PasswordFile string `yaml:"password-file"`
+	Encrypt      bool   `yaml:"encrypt"`
+
+	Exclude     []string `yaml:"exclude"`
+	Include     []string `yaml:"include"`
+	ExcludeFrom []string `yaml:"exclude-from"`
+	MinSize     string   `yaml:"min-size"`
+	MaxSize     string   `yaml:"max-size"`
+	NewerThan   string   `yaml:"newer-than"`
+	OlderThan   string   `yaml:"older-than"`
+
+	Tags []string `yaml:"tags"`
+
+	// Compression is a codec spec like "zstd:19", or "none".
+	Compression string    `yaml:"compression"`
+	Jobs        int       `yaml:"jobs"`
+	Keep        Retention `yaml:"keep"`
+}
+
+// Retention is the policy forget applies with the profile.
+type Retention struct {
+	Last    int `yaml:"last"`
+	Daily   int `yaml:"daily"`
+	Weekly  int `yaml:"weekly"`
+	Monthly int `yaml:"monthly"`
+	Yearly  int `yaml:"yearly"`
+}
+
+// DefaultPath is $XDG_CONFIG_HOME/capivara-sync/config.yaml, with
+// ~/.config when XDG_CONFIG_HOME is not set.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "capivara-sync", "config.yaml")
+}
+
+// Load reads a configuration file, unknown keys are errors so a typo does
+// not silently drop an option.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// GetProfile returns a profile with the destination of its repository filled
+// in, the values of the profile win over the repository ones.
+func (c *Config) GetProfile(name string) (Profile, error) {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %q not found, the configuration has %s", name, names(c.Profiles))
+	}
+
+	if profile.Repository != "" {
+		repo, ok := c.Repositories[profile.Repository]
+		if !ok {
+			return Profile{}, fmt.Errorf("profile %q uses unknown repository %q", name, profile.Repository)
+		}
+		if profile.Dest == "" {
+			profile.Dest = repo.URL
+		}
+		if profile.DestUser == "" {
+			profile.DestUser = repo.User
+		}
+		if profile.DestPassword == "" {
+			profile.DestPassword = repo.Password
+		}
+		if profile.PasswordFile == "" {
+			profile.PasswordFile = repo.PasswordFile
+		}
+		profile.Encrypt = profile.Encrypt || repo.Encrypt
+	}
+
+	profile.Origin = ExpandHome(profile.Origin)
+	profile.Dest = ExpandHome(profile.Dest)
+	profile.PasswordFile = ExpandHome(profile.PasswordFile)
+	excludeFrom := make([]string, len(profile.ExcludeFrom))
+	for i, file := range profile.ExcludeFrom {
+		excludeFrom[i] = ExpandHome(file)
+	}
+	profile.ExcludeFrom = excludeFrom
+	return profile, nil
+}
+
+// ExpandHome replaces a leading ~/ with the home directory.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+func names(profiles map[string]Profile) string {
+	if len(profiles) == 0 {
+		return "no profiles"
+	}
+	var list []string
+	for name := range profiles {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return "profiles " + strings.Join(list, ", ")
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sample = `
+repositories:
+  nas:
+    url: backup@nas:/srv/backups
+    password-file: ~/.config/capivara-sync/nas.pass
+    encrypt: true
+profiles:
+  photos:
+    origin: ~/Pictures
+    repository: nas
+    exclude: ["*.tmp", "cache/"]
+    compression: zstd:19
+    jobs: 8
+    keep:
+      daily: 7
+      monthly: 12
+  direct:
+    origin: /srv/www
+    dest: /mnt/usb/www
+    dest-user: someone
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestGetProfile(t *testing.T) {
+	t.Setenv("HOME", "/home/someone")
+	cfg, err := Load(writeConfig(t, sample))
+	require.NoError(t, err)
+
+	photos, err := cfg.GetProfile("photos")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/someone/Pictures", photos.Origin)
+	assert.Equal(t, "backup@nas:/srv/backups", photos.Dest)
+	assert.Equal(t, "/home/someone/.config/capivara-sync/nas.pass", photos.PasswordFile)
+	assert.True(t, photos.Encrypt)
+	assert.Equal(t, []string{"*.tmp", "cache/"}, photos.Exclude)
+	assert.Equal(t, "zstd:19", photos.Compression)
+	assert.Equal(t, 8, photos.Jobs)
+	assert.Equal(t, Retention{Daily: 7, Monthly: 12}, photos.Keep)
+
+	direct, err := cfg.GetProfile("direct")
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/usb/www", direct.Dest)
+	assert.Equal(t, "someone", direct.DestUser)
+	assert.False(t, direct.Encrypt)
+
+	_, err = cfg.GetProfile("music")
+	assert.ErrorContains(t, err, "profiles direct, photos")
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(writeConfig(t, "profiles:\n  a:\n    orign: /tmp\n"))
+	assert.ErrorContains(t, err, "orign")
+
+	cfg, err := Load(writeConfig(t, "profiles:\n  a:\n    repository: missing\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetProfile("a")
+	assert.ErrorContains(t, err, "unknown repository")
+
+	cfg, err = Load(writeConfig(t, ""))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Profiles)
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// BlockRecord is a chunk of file content stored once on the destination,
+// Hash is the MD5 of the uncompressed chunk.
+type BlockRecord struct {
+	Hash       string
+	RemoteHash string
+	Size       int64
+	StoredSize int64
+	// Codec is the name of the compressor codec the block is stored with.
+	Codec string
+}
+
+const blockColumns = `hash, remote_hash, size, stored_size, codec`
+
+func scanBlock(row scanner) (BlockRecord, error) {
+	var b BlockRecord
+	err := row.Scan(&b.Hash, &b.RemoteHash, &b.Size, &b.StoredSize, &b.Codec)
+	return b, err
+}
+
+func SaveBlock(db *sql.DB, b BlockRecord) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO blocks (`+blockColumns+`) VALUES (?, ?, ?, ?, ?)`,
+		b.Hash, b.RemoteHash, b.Size, b.StoredSize, b.Codec)
+	if err != nil {
+		return fmt.Errorf("failed to save block: %w", err)
+	}
+	return nil
+}
+
+func GetBlock(db *sql.DB, hash string) (*BlockRecord, error) {
+	b, err := scanBlock(db.QueryRow(`SELECT `+blockColumns+` FROM blocks WHERE hash = ?`, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No matching record found
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+// SaveFileChunks records the ordered list of blocks making up the content
+// identified by fileMd5.
+func SaveFileChunks(db *sql.DB, fileMd5 string, blocks []string) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM file_chunks WHERE file_md5 = ?`, fileMd5); err != nil {
+		return fmt.Errorf("failed to clear file chunks: %w", err)
+	}
+	for seq, hash := range blocks {
+		if _, err = tx.Exec(`INSERT INTO file_chunks (file_md5, seq, block_hash) VALUES (?, ?, ?)`, fileMd5, seq, hash); err != nil {
+			return fmt.Errorf("failed to save file chunk: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetFileChunks returns the block hashes of a file content in order.
+func GetFileChunks(db *sql.DB, fileMd5 string) ([]string, error) {
+	rows, err := db.Query(`SELECT block_hash FROM file_chunks WHERE file_md5 = ? ORDER BY seq`, fileMd5)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, hash)
+	}
+	return blocks, rows.Err()
+}
+
+// UnreferencedBlocks returns the blocks no file of any snapshot uses anymore.
+func UnreferencedBlocks(db *sql.DB) ([]BlockRecord, error) {
+	rows, err := db.Query(`SELECT ` + blockColumns + ` FROM blocks
+		WHERE hash NOT IN (
+			SELECT block_hash FROM file_chunks
+			WHERE file_md5 IN (SELECT md5 FROM snapshot_files)
+		)
+		ORDER BY hash`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []BlockRecord
+	for rows.Next() {
+		b, err := scanBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// PruneBlocks forgets the chunk lists of contents no snapshot holds and the
+// given blocks.
+func PruneBlocks(db *sql.DB, blocks []BlockRecord) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM file_chunks WHERE file_md5 NOT IN (SELECT md5 FROM snapshot_files)`); err != nil {
+		return fmt.Errorf("failed to delete file chunks: %w", err)
+	}
+	for _, b := range blocks {
+		if _, err = tx.Exec(`DELETE FROM blocks WHERE hash = ?`, b.Hash); err != nil {
+			return fmt.Errorf("failed to delete block: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -2,15 +2,112 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
 	_ "modernc.org/sqlite"
 )
 
+// migrations upgrade the schema created by InitDB, the index of each entry
+// is the schema version (PRAGMA user_version) it upgrades from.
+var migrations = []string{
+	// v0 -> v1: snapshot_files becomes a per-snapshot manifest keyed on
+	// (snapshot_id, original_path) instead of being overwritten by every backup.
+	`
+	CREATE TABLE snapshot_files_v1 (
+		snapshot_id INTEGER NOT NULL,
+		original_path TEXT NOT NULL,
+		md5 TEXT NOT NULL,
+		permission TEXT,
+		size INTEGER DEFAULT 0,
+		mtime TEXT DEFAULT '',
+		remote_hash TEXT DEFAULT '',
+		status TEXT DEFAULT 'pending',
+		PRIMARY KEY (snapshot_id, original_path)
+	);
+	INSERT INTO snapshot_files_v1 (snapshot_id, original_path, md5, permission, remote_hash, status)
+		SELECT COALESCE(snapshot_id, 0), original_path, md5, COALESCE(permission, ''), COALESCE(remote_hash, ''), COALESCE(status, 'pending')
+		FROM snapshot_files;
+	DROP TABLE snapshot_files;
+	ALTER TABLE snapshot_files_v1 RENAME TO snapshot_files;
+	CREATE INDEX IF NOT EXISTS idx_snapshot_files_md5 ON snapshot_files (md5);`,
+	// v1 -> v2: files are stored as content-defined chunks, each chunk once
+	// in a block. Files backed up before were stored whole in block_<md5>.zst,
+	// they become single chunk files.
+	`
+	CREATE TABLE blocks (
+		hash TEXT PRIMARY KEY,
+		remote_hash TEXT DEFAULT '',
+		size INTEGER DEFAULT 0,
+		stored_size INTEGER DEFAULT 0
+	);
+	CREATE TABLE file_chunks (
+		file_md5 TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		block_hash TEXT NOT NULL,
+		PRIMARY KEY (file_md5, seq)
+	);
+	CREATE INDEX idx_file_chunks_block ON file_chunks (block_hash);
+	INSERT INTO blocks (hash, remote_hash, size)
+		SELECT md5, MAX(remote_hash), MAX(size) FROM snapshot_files GROUP BY md5;
+	INSERT INTO file_chunks (file_md5, seq, block_hash)
+		SELECT DISTINCT md5, 0, md5 FROM snapshot_files;`,
+	// v2 -> v3: blocks record the codec they are compressed with, the ones
+	// stored before were all zstd.
+	`ALTER TABLE blocks ADD COLUMN codec TEXT NOT NULL DEFAULT 'zstd';`,
+	// v3 -> v4: snapshots describe where they come from and what they hold,
+	// the counts of older snapshots are taken from their manifest.
+	`
+	ALTER TABLE snapshots ADD COLUMN hostname TEXT NOT NULL DEFAULT '';
+	ALTER TABLE snapshots ADD COLUMN username TEXT NOT NULL DEFAULT '';
+	ALTER TABLE snapshots ADD COLUMN origin TEXT NOT NULL DEFAULT '';
+	ALTER TABLE snapshots ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	ALTER TABLE snapshots ADD COLUMN file_count INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE snapshots ADD COLUMN total_bytes INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE snapshots ADD COLUMN new_bytes INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE snapshots ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE snapshot_tags (
+		snapshot_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (snapshot_id, tag)
+	);
+	UPDATE snapshots SET
+		file_count = (SELECT COUNT(*) FROM snapshot_files WHERE snapshot_id = snapshots.id),
+		total_bytes = (SELECT COALESCE(SUM(size), 0) FROM snapshot_files WHERE snapshot_id = snapshots.id);`,
+	// v4 -> v5: backups mark their snapshot in_progress until they end, the
+	// snapshots taken before were left pending and are taken as complete.
+	`UPDATE snapshots SET status = 'complete' WHERE status IS NULL OR status = 'pending';`,
+	// v5 -> v6: the full mode, access time and ownership of the files, the
+	// owner is NULL when the source has none.
+	`
+	ALTER TABLE snapshot_files ADD COLUMN mode INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE snapshot_files ADD COLUMN atime TEXT NOT NULL DEFAULT '';
+	ALTER TABLE snapshot_files ADD COLUMN uid INTEGER;
+	ALTER TABLE snapshot_files ADD COLUMN gid INTEGER;
+	ALTER TABLE snapshot_files ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+	ALTER TABLE snapshot_files ADD COLUMN grp TEXT NOT NULL DEFAULT '';`,
+	// v6 -> v7: the entry type of the files with the target of links and the
+	// device number of devices, the older snapshots only hold files.
+	`
+	ALTER TABLE snapshot_files ADD COLUMN type TEXT NOT NULL DEFAULT 'file';
+	ALTER TABLE snapshot_files ADD COLUMN link_target TEXT NOT NULL DEFAULT '';
+	ALTER TABLE snapshot_files ADD COLUMN device INTEGER NOT NULL DEFAULT 0;`,
+	// v7 -> v8: the extended attributes of the files as a JSON object of
+	// their base64 values by name, empty when they have none.
+	`ALTER TABLE snapshot_files ADD COLUMN xattrs TEXT NOT NULL DEFAULT '';`,
+}
+
 func InitDB(filename string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite", filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	// the workers share the database, one connection serializes their access
+	db.SetMaxOpenConns(1)
 
 	// Ensure database is closed on error
 	defer func() {
@@ -38,10 +135,42 @@ func InitDB(filename string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
 
+	if err = migrate(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
-func SaveFileInfo(db *sql.DB, originalPath, md5, permission string, snapshotID int, remoteHash, status string) error {
+// migrate applies every migration newer than the database schema version.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration: %w", err)
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to migrate schema to version %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to set schema version: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration: %w", err)
+		}
+	}
+	return nil
+}
+
+// SaveFileInfo records a file in the manifest of the snapshot f.SnapId.
+func SaveFileInfo(db *sql.DB, f FileRecord) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -60,15 +189,36 @@ func SaveFileInfo(db *sql.DB, originalPath, md5, permission string, snapshotID i
 	}()
 
 	stmt, err := tx.Prepare(`
-		INSERT OR REPLACE INTO snapshot_files 
-		(original_path, md5, permission, snapshot_id, remote_hash, status) 
-		VALUES (?, ?, ?, ?, ?, ?)`)
+		INSERT OR REPLACE INTO snapshot_files
+		(snapshot_id, original_path, md5, permission, size, mtime, remote_hash, status, mode, atime, uid, gid, owner, grp,
+		type, link_target, device, xattrs)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(originalPath, md5, permission, snapshotID, remoteHash, status)
+	var uid, gid sql.NullInt64
+	var owner, group string
+	entryType := f.Type
+	if entryType == "" {
+		entryType = "file"
+	}
+	xattrs := ""
+	if len(f.Xattrs) > 0 {
+		data, err := json.Marshal(f.Xattrs)
+		if err != nil {
+			return fmt.Errorf("failed to encode extended attributes: %w", err)
+		}
+		xattrs = string(data)
+	}
+	if f.Owner != nil {
+		uid = sql.NullInt64{Int64: int64(f.Owner.Uid), Valid: true}
+		gid = sql.NullInt64{Int64: int64(f.Owner.Gid), Valid: true}
+		owner, group = f.Owner.User, f.Owner.Group
+	}
+	_, err = stmt.Exec(f.SnapId, f.Path, f.MD5, f.Permission, f.Size, formatTime(f.Modified), f.RemoteHash, f.Status,
+		uint32(f.Mode), formatTime(f.Accessed), uid, gid, owner, group, entryType, f.LinkTarget, int64(f.Device), xattrs)
 	if err != nil {
 		return fmt.Errorf("failed to execute statement: %w", err)
 	}
@@ -85,35 +235,106 @@ type FileRecord struct {
 	MD5        string
 	Permission string
 	SnapId     int
+	Size       int64
+	Modified   time.Time
 	RemoteHash string
 	Status     string
+	// Mode is the full mode with the setuid, setgid and sticky bits, 0 for
+	// the files of older snapshots which only have Permission.
+	Mode     os.FileMode
+	Accessed time.Time
+	// Owner is nil when the source has no ownership.
+	Owner *Owner
+	// Type is the entry type, "file" for a regular file. LinkTarget is the
+	// target of a symlink or the path a hardlink shares its content with,
+	// Device the device number of a device.
+	Type       string
+	LinkTarget string
+	Device     uint64
+	// Xattrs are the extended attributes by name, nil when none were read.
+	Xattrs map[string][]byte
 }
 
-func ListFiles(db *sql.DB) ([]FileRecord, error) {
-	rows, err := db.Query(`SELECT original_path, md5, permission, snapshot_id, remote_hash, status FROM snapshot_files ORDER BY original_path`)
-	if err != nil {
-		return nil, err
+// Owner is the ownership of a file, the names are empty when unknown.
+type Owner struct {
+	Uid   int
+	Gid   int
+	User  string
+	Group string
+}
+
+const fileColumns = `original_path, md5, permission, snapshot_id, size, mtime, remote_hash, status, mode, atime, uid, gid, owner, grp, type, link_target, device, xattrs`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFile(row scanner) (FileRecord, error) {
+	var f FileRecord
+	var modTimeStr, accessTimeStr, owner, group string
+	var mode uint32
+	var uid, gid sql.NullInt64
+	var device int64
+	var xattrs string
+	if err := row.Scan(&f.Path, &f.MD5, &f.Permission, &f.SnapId, &f.Size, &modTimeStr, &f.RemoteHash, &f.Status,
+		&mode, &accessTimeStr, &uid, &gid, &owner, &group, &f.Type, &f.LinkTarget, &device, &xattrs); err != nil {
+		return f, err
 	}
+	if xattrs != "" {
+		if err := json.Unmarshal([]byte(xattrs), &f.Xattrs); err != nil {
+			return f, fmt.Errorf("failed to decode extended attributes of %s: %w", f.Path, err)
+		}
+	}
+	f.Device = uint64(device)
+	f.Modified = parseTime(modTimeStr)
+	f.Accessed = parseTime(accessTimeStr)
+	f.Mode = os.FileMode(mode)
+	if uid.Valid && gid.Valid {
+		f.Owner = &Owner{Uid: int(uid.Int64), Gid: int(gid.Int64), User: owner, Group: group}
+	}
+	return f, nil
+}
+
+func scanFiles(rows *sql.Rows) ([]FileRecord, error) {
 	defer rows.Close()
 
 	var files []FileRecord
-
 	for rows.Next() {
-		var f FileRecord
-		// var modTimeStr string
-		if err := rows.Scan(&f.Path, &f.MD5, &f.Permission, &f.SnapId, &f.RemoteHash, &f.Status); err != nil {
+		f, err := scanFile(rows)
+		if err != nil {
 			return nil, err
 		}
-		// f.Modified, _ = time.Parse(time.RFC3339, modTimeStr)
 		files = append(files, f)
 	}
-	return files, nil
+	return files, rows.Err()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func ListFiles(db *sql.DB) ([]FileRecord, error) {
+	rows, err := db.Query(`SELECT ` + fileColumns + ` FROM snapshot_files ORDER BY original_path, snapshot_id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
 }
 
 func GetFileByHash(db *sql.DB, hash string) (*FileRecord, error) {
-	var f FileRecord
-	query := `SELECT original_path, md5, permission, snapshot_id, remote_hash, status FROM snapshot_files WHERE md5 = ?`
-	err := db.QueryRow(query, hash).Scan(&f.Path, &f.MD5, &f.Permission, &f.SnapId, &f.RemoteHash, &f.Status)
+	query := `SELECT ` + fileColumns + ` FROM snapshot_files WHERE md5 = ? ORDER BY snapshot_id DESC LIMIT 1`
+	f, err := scanFile(db.QueryRow(query, hash))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No matching record found
@@ -123,89 +344,244 @@ func GetFileByHash(db *sql.DB, hash string) (*FileRecord, error) {
 	return &f, nil
 }
 
+// ListFilesbySnapshot returns the manifest of a snapshot, the files as they
+// were when the snapshot was taken.
 func ListFilesbySnapshot(db *sql.DB, snapshot_id int) ([]FileRecord, error) {
-	rows, err := db.Query(`SELECT original_path, md5, permission, snapshot_id, remote_hash,status FROM snapshot_files WHERE snapshot_id = ? ORDER BY original_path`, snapshot_id)
+	rows, err := db.Query(`SELECT `+fileColumns+` FROM snapshot_files WHERE snapshot_id = ? ORDER BY original_path`, snapshot_id)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var files []FileRecord
-	for rows.Next() {
-		var f FileRecord
-		if err := rows.Scan(&f.Path, &f.MD5, &f.Permission, &f.SnapId, &f.RemoteHash, &f.Status); err != nil {
-			return nil, err
-		}
-		files = append(files, f)
+	return scanFiles(rows)
+}
+
+// DeleteFileInfo removes a path from the manifest of a snapshot.
+func DeleteFileInfo(db *sql.DB, snapshot_id int, path string) error {
+	if _, err := db.Exec(`DELETE FROM snapshot_files WHERE snapshot_id = ? AND original_path = ?`, snapshot_id, path); err != nil {
+		return fmt.Errorf("failed to delete file %s of snapshot %d: %w", path, snapshot_id, err)
 	}
-	return files, nil
+	return nil
 }
 
-func SaveSnapshot(db *sql.DB) (int64, error) {
-	stmt, err := db.Prepare("INSERT INTO snapshots (date) VALUES (datetime('now'))")
+// Snapshot statuses, a snapshot is in_progress while its backup runs and
+// only complete snapshots are restored by default.
+const (
+	SnapshotInProgress = "in_progress"
+	SnapshotComplete   = "complete"
+	SnapshotFailed     = "failed"
+)
+
+// SaveSnapshot starts a snapshot taken now, in progress, described by the
+// hostname, user, origin, tags and description of s.
+func SaveSnapshot(db *sql.DB, s SnapShotRecord) (id int64, err error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer stmt.Close()
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
 
-	result, err := stmt.Exec()
+	result, err := tx.Exec(`INSERT INTO snapshots (date, status, hostname, username, origin, description)
+		VALUES (datetime('now'), ?, ?, ?, ?, ?)`, SnapshotInProgress, s.Hostname, s.User, s.Origin, s.Description)
 	if err != nil {
 		return 0, err
 	}
+	if id, err = result.LastInsertId(); err != nil {
+		return 0, err
+	}
+	for _, tag := range s.Tags {
+		if _, err = tx.Exec(`INSERT OR IGNORE INTO snapshot_tags (snapshot_id, tag) VALUES (?, ?)`, id, tag); err != nil {
+			return 0, fmt.Errorf("failed to save tag: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return id, nil
+}
 
-	return result.LastInsertId()
+// SaveSnapshotStats records the statistics of the backup that took the
+// snapshot s.Id.
+func SaveSnapshotStats(db *sql.DB, s SnapShotRecord) error {
+	_, err := db.Exec(`UPDATE snapshots SET file_count = ?, total_bytes = ?, new_bytes = ?, duration_ms = ? WHERE id = ?`,
+		s.FileCount, s.TotalBytes, s.NewBytes, s.Duration.Milliseconds(), s.Id)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot statistics: %w", err)
+	}
+	return nil
+}
+
+// SetSnapshotStatus moves a snapshot to status.
+func SetSnapshotStatus(db *sql.DB, id int, status string) error {
+	if _, err := db.Exec(`UPDATE snapshots SET status = ? WHERE id = ?`, status, id); err != nil {
+		return fmt.Errorf("failed to set snapshot status: %w", err)
+	}
+	return nil
 }
 
 type SnapShotRecord struct {
 	Id     int
 	Date   string
 	Status string
+
+	Hostname string
+	User     string
+	// Origin is the path or URL of the source the snapshot was taken from.
+	Origin      string
+	Tags        []string
+	Description string
+
+	FileCount  int64
+	TotalBytes int64
+	// NewBytes is what the backup stored in the destination, after
+	// deduplication and compression.
+	NewBytes int64
+	Duration time.Duration
 }
 
-func ListSnapShots(db *sql.DB) ([]SnapShotRecord, error) {
-	rows, err := db.Query(`SELECT id, date, status FROM snapshots ORDER BY date`)
+// Complete reports whether the backup taking the snapshot finished without
+// errors.
+func (s SnapShotRecord) Complete() bool {
+	return s.Status == SnapshotComplete
+}
+
+const snapshotColumns = `id, date, status, hostname, username, origin, description, file_count, total_bytes, new_bytes, duration_ms`
+
+func scanSnapshot(row scanner) (SnapShotRecord, error) {
+	var s SnapShotRecord
+	var status sql.NullString
+	var duration int64
+	if err := row.Scan(&s.Id, &s.Date, &status, &s.Hostname, &s.User, &s.Origin, &s.Description,
+		&s.FileCount, &s.TotalBytes, &s.NewBytes, &duration); err != nil {
+		return s, err
+	}
+	s.Status = status.String
+	s.Duration = time.Duration(duration) * time.Millisecond
+	return s, nil
+}
+
+// querySnapshots returns the snapshots a query selects, with their tags.
+func querySnapshots(db *sql.DB, query string, args ...any) ([]SnapShotRecord, error) {
+	rows, err := db.Query(`SELECT `+snapshotColumns+` FROM snapshots `+query, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
 	var snaps []SnapShotRecord
-
 	for rows.Next() {
-		var f SnapShotRecord
-		// var modTimeStr string
-		if err := rows.Scan(&f.Id, &f.Date, &f.Status); err != nil {
+		s, err := scanSnapshot(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snaps = append(snaps, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range snaps {
+		if snaps[i].Tags, err = snapshotTags(db, snaps[i].Id); err != nil {
 			return nil, err
 		}
-		// f.Modified, _ = time.Parse(time.RFC3339, modTimeStr)
-		snaps = append(snaps, f)
 	}
 	return snaps, nil
 }
 
-func GetSnapByDate(db *sql.DB, date string) (*SnapShotRecord, error) {
-	var f SnapShotRecord
-	query := `SELECT id, date, status FROM snapshots WHERE date = ?`
-	err := db.QueryRow(query, date).Scan(&f.Id, &f.Date, &f.Status)
+func snapshotTags(db *sql.DB, id int) ([]string, error) {
+	rows, err := db.Query(`SELECT tag FROM snapshot_tags WHERE snapshot_id = ? ORDER BY tag`, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No matching record found
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	defer rows.Close()
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
 		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// querySnapshot returns the first snapshot a query selects, nil when none.
+func querySnapshot(db *sql.DB, query string, args ...any) (*SnapShotRecord, error) {
+	snaps, err := querySnapshots(db, query+` LIMIT 1`, args...)
+	if err != nil || len(snaps) == 0 {
 		return nil, err
 	}
-	return &f, nil
+	return &snaps[0], nil
+}
+
+func ListSnapShots(db *sql.DB) ([]SnapShotRecord, error) {
+	return querySnapshots(db, `ORDER BY date`)
+}
+
+// GetSnapByDate returns the complete snapshot taken at date, or the latest
+// one taken before it so a partial date like "2025-05-01" selects the state
+// of that day.
+func GetSnapByDate(db *sql.DB, date string) (*SnapShotRecord, error) {
+	return querySnapshot(db, `WHERE status = ? AND substr(date, 1, length(?)) <= ? ORDER BY date DESC, id DESC`, SnapshotComplete, date, date)
+}
+
+// GetSnapshot returns the snapshot of an id whatever its status, nil when
+// there is none.
+func GetSnapshot(db *sql.DB, id int) (*SnapShotRecord, error) {
+	return querySnapshot(db, `WHERE id = ?`, id)
 }
 
+// GetLastSnap returns the latest complete snapshot.
 func GetLastSnap(db *sql.DB) (*SnapShotRecord, error) {
-	var f SnapShotRecord
-	query := `SELECT id, date, status FROM snapshots ORDER BY date DESC LIMIT 1`
-	err := db.QueryRow(query).Scan(&f.Id, &f.Date, &f.Status)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No matching record found
-		}
+	return querySnapshot(db, `WHERE status = ? ORDER BY date DESC, id DESC`, SnapshotComplete)
+}
+
+// GetInterruptedSnap returns the latest snapshot when its backup did not
+// complete, nil when the latest snapshot is complete.
+func GetInterruptedSnap(db *sql.DB) (*SnapShotRecord, error) {
+	snap, err := querySnapshot(db, `ORDER BY date DESC, id DESC`)
+	if err != nil || snap == nil || snap.Complete() {
 		return nil, err
 	}
-	return &f, nil
+	return snap, nil
+}
+
+// SnapshotFilter selects snapshots by their metadata, the empty fields
+// select every snapshot.
+type SnapshotFilter struct {
+	// Tags all have to be tags of the snapshot.
+	Tags []string
+	Host string
+	// Path is the origin of the snapshot.
+	Path string
+}
+
+func (f SnapshotFilter) Match(s SnapShotRecord) bool {
+	if f.Host != "" && f.Host != s.Hostname {
+		return false
+	}
+	if f.Path != "" && strings.TrimSuffix(f.Path, "/") != strings.TrimSuffix(s.Origin, "/") {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !slices.Contains(s.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterSnapshots returns the snapshots the filter matches.
+func FilterSnapshots(snaps []SnapShotRecord, f SnapshotFilter) []SnapShotRecord {
+	var matched []SnapShotRecord
+	for _, s := range snaps {
+		if f.Match(s) {
+			matched = append(matched, s)
+		}
+	}
+	return matched
 }
 
 func UpdateSnapshotFileField(db *sql.DB, recordID int, fieldName string, newValue any) error {
@@ -215,6 +591,8 @@ func UpdateSnapshotFileField(db *sql.DB, recordID int, fieldName string, newValu
 		"md5":           true,
 		"permission":    true,
 		"snapshot_id":   true,
+		"size":          true,
+		"mtime":         true,
 		"remote_hash":   true,
 		"status":        true,
 	}
@@ -230,3 +608,31 @@ func UpdateSnapshotFileField(db *sql.DB, recordID int, fieldName string, newValu
 
 	return nil
 }
+
+// DeleteSnapshot removes a snapshot and its manifest, blocks only used by it
+// are left for PruneBlocks.
+func DeleteSnapshot(db *sql.DB, id int) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM snapshot_files WHERE snapshot_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete snapshot files: %w", err)
+	}
+	if _, err = tx.Exec(`DELETE FROM snapshot_tags WHERE snapshot_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete snapshot tags: %w", err)
+	}
+	if _, err = tx.Exec(`DELETE FROM snapshots WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
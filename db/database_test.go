@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateLegacySnapshotFiles(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "snapshot_files.db")
+
+	legacy, err := sql.Open("sqlite", filename)
+	require.NoError(t, err)
+	_, err = legacy.Exec(`
+	CREATE TABLE snapshot_files (
+		original_path TEXT PRIMARY KEY,
+		md5 TEXT NOT NULL,
+		permission TEXT,
+		snapshot_id INTEGER,
+		remote_hash TEXT,
+		status TEXT DEFAULT 'pending'
+	);
+	CREATE TABLE snapshots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		status TEXT DEFAULT 'pending'
+	);
+	INSERT INTO snapshots (date) VALUES ('2025-05-01 10:00:00');
+	INSERT INTO snapshot_files (original_path, md5, permission, snapshot_id, remote_hash, status)
+		VALUES ('a.txt', 'aaa', '-rw-r--r--', 1, 'raaa', 'upload');`)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Close())
+
+	database, err := InitDB(filename)
+	require.NoError(t, err)
+	defer database.Close()
+
+	files, err := ListFilesbySnapshot(database, 1)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "a.txt", files[0].Path)
+	assert.Equal(t, "aaa", files[0].MD5)
+	assert.Equal(t, "raaa", files[0].RemoteHash)
+
+	// legacy whole file blocks become single chunk files
+	blocks, err := GetFileChunks(database, "aaa")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aaa"}, blocks)
+	block, err := GetBlock(database, "aaa")
+	require.NoError(t, err)
+	require.NotNil(t, block)
+	assert.Equal(t, "raaa", block.RemoteHash)
+	assert.Equal(t, "zstd", block.Codec)
+
+	// the statistics of legacy snapshots come from their manifest
+	snap, err := GetLastSnap(database)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), snap.FileCount)
+	assert.Empty(t, snap.Hostname)
+}
+
+func TestSnapshotsKeepTheirOwnManifest(t *testing.T) {
+	database, err := InitDB(filepath.Join(t.TempDir(), "snapshot_files.db"))
+	require.NoError(t, err)
+	defer database.Close()
+
+	first, err := SaveSnapshot(database, SnapShotRecord{})
+	require.NoError(t, err)
+	second, err := SaveSnapshot(database, SnapShotRecord{})
+	require.NoError(t, err)
+
+	modified := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, SaveFileInfo(database, FileRecord{Path: "a.txt", MD5: "v1", Permission: "-rw-r--r--", SnapId: int(first), Size: 2, Modified: modified}))
+	require.NoError(t, SaveFileInfo(database, FileRecord{Path: "b.txt", MD5: "b1", Permission: "-rw-r--r--", SnapId: int(first), Size: 3,
+		Mode: 0755 | os.ModeSetuid, Accessed: modified.Add(time.Hour), Owner: &Owner{Uid: 1000, Gid: 100, User: "alice", Group: "users"},
+		Xattrs: map[string][]byte{"user.origin": []byte("camera"), "security.selinux": []byte("system_u:object_r:user_home_t:s0\x00")}}))
+	require.NoError(t, SaveFileInfo(database, FileRecord{Path: "c.txt", Permission: "-rwxrwxrwx", SnapId: int(first),
+		Type: "symlink", LinkTarget: "b.txt"}))
+	require.NoError(t, SaveFileInfo(database, FileRecord{Path: "a.txt", MD5: "v2", Permission: "-rw-r--r--", SnapId: int(second), Size: 4}))
+
+	files, err := ListFilesbySnapshot(database, int(first))
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	assert.Equal(t, "v1", files[0].MD5)
+	assert.Equal(t, int64(2), files[0].Size)
+	assert.True(t, modified.Equal(files[0].Modified))
+	assert.Nil(t, files[0].Owner)
+	assert.Equal(t, 0755|os.ModeSetuid, files[1].Mode)
+	assert.True(t, modified.Add(time.Hour).Equal(files[1].Accessed))
+	assert.Equal(t, &Owner{Uid: 1000, Gid: 100, User: "alice", Group: "users"}, files[1].Owner)
+	assert.Equal(t, "file", files[1].Type)
+	assert.Equal(t, map[string][]byte{"user.origin": []byte("camera"), "security.selinux": []byte("system_u:object_r:user_home_t:s0\x00")}, files[1].Xattrs)
+	assert.Nil(t, files[0].Xattrs)
+	assert.Equal(t, "symlink", files[2].Type)
+	assert.Equal(t, "b.txt", files[2].LinkTarget)
+
+	files, err = ListFilesbySnapshot(database, int(second))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "v2", files[0].MD5)
+}
+
+func TestGetSnapByDate(t *testing.T) {
+	database, err := InitDB(filepath.Join(t.TempDir(), "snapshot_files.db"))
+	require.NoError(t, err)
+	defer database.Close()
+
+	_, err = database.Exec(`INSERT INTO snapshots (date, status) VALUES
+		('2025-05-01 10:00:00', 'complete'), ('2025-05-01 18:00:00', 'complete'), ('2025-05-01 20:00:00', 'failed'),
+		('2025-05-03 09:00:00', 'complete')`)
+	require.NoError(t, err)
+
+	snap, err := GetSnapByDate(database, "2025-05-01 10:00:00")
+	require.NoError(t, err)
+	assert.Equal(t, 1, snap.Id)
+
+	snap, err = GetSnapByDate(database, "2025-05-02")
+	require.NoError(t, err)
+	assert.Equal(t, 2, snap.Id)
+
+	snap, err = GetSnapByDate(database, "2025-04-30")
+	require.NoError(t, err)
+	assert.Nil(t, snap)
+}
+
+func TestSnapshotMetadata(t *testing.T) {
+	database, err := InitDB(filepath.Join(t.TempDir(), "snapshot_files.db"))
+	require.NoError(t, err)
+	defer database.Close()
+
+	id, err := SaveSnapshot(database, SnapShotRecord{
+		Hostname:    "laptop",
+		User:        "alice",
+		Origin:      "/home/alice/photos",
+		Tags:        []string{"weekly", "photos", "weekly"},
+		Description: "before the trip",
+	})
+	require.NoError(t, err)
+	require.NoError(t, SaveSnapshotStats(database, SnapShotRecord{Id: int(id), FileCount: 3, TotalBytes: 300, NewBytes: 120, Duration: 1500 * time.Millisecond}))
+	_, err = SaveSnapshot(database, SnapShotRecord{Hostname: "server", Origin: "/srv", Tags: []string{"daily"}})
+	require.NoError(t, err)
+
+	snaps, err := ListSnapShots(database)
+	require.NoError(t, err)
+	require.Len(t, snaps, 2)
+	first := snaps[0]
+	assert.Equal(t, "laptop", first.Hostname)
+	assert.Equal(t, "alice", first.User)
+	assert.Equal(t, "/home/alice/photos", first.Origin)
+	assert.Equal(t, []string{"photos", "weekly"}, first.Tags)
+	assert.Equal(t, "before the trip", first.Description)
+	assert.Equal(t, int64(3), first.FileCount)
+	assert.Equal(t, int64(300), first.TotalBytes)
+	assert.Equal(t, int64(120), first.NewBytes)
+	assert.Equal(t, 1500*time.Millisecond, first.Duration)
+
+	ids := func(filter SnapshotFilter) []int {
+		var ids []int
+		for _, snap := range FilterSnapshots(snaps, filter) {
+			ids = append(ids, snap.Id)
+		}
+		return ids
+	}
+	assert.Equal(t, []int{1, 2}, ids(SnapshotFilter{}))
+	assert.Equal(t, []int{1}, ids(SnapshotFilter{Tags: []string{"weekly", "photos"}}))
+	assert.Empty(t, ids(SnapshotFilter{Tags: []string{"weekly", "daily"}}))
+	assert.Equal(t, []int{2}, ids(SnapshotFilter{Host: "server"}))
+	assert.Equal(t, []int{1}, ids(SnapshotFilter{Path: "/home/alice/photos/"}))
+
+	require.NoError(t, DeleteSnapshot(database, int(id)))
+	var tags int
+	require.NoError(t, database.QueryRow(`SELECT COUNT(*) FROM snapshot_tags`).Scan(&tags))
+	assert.Equal(t, 1, tags)
+}
@@ -0,0 +1,102 @@
+package encryptor
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key is the repository master key, it never leaves the client unwrapped.
+type Key struct {
+	// Data encrypts blocks and the snapshot database.
+	Data []byte
+	// Id derives block names so they do not reveal the content hash.
+	Id []byte
+}
+
+// KeyFile is the master key wrapped with a key derived from the passphrase,
+// as stored on the destination.
+type KeyFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+var ErrWrongPassword = errors.New("wrong password or corrupted key file")
+
+func NewKey() (*Key, error) {
+	buf := make([]byte, 2*chacha20poly1305.KeySize)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return &Key{Data: buf[:chacha20poly1305.KeySize], Id: buf[chacha20poly1305.KeySize:]}, nil
+}
+
+// Wrap encrypts the key with the passphrase and returns the key file content.
+func (k *Key) Wrap(passphrase string) ([]byte, error) {
+	kf := KeyFile{Version: 1, KDF: "scrypt", N: 1 << 15, R: 8, P: 1}
+	kf.Salt = make([]byte, 32)
+	kf.Nonce = make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(kf.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(kf.Nonce); err != nil {
+		return nil, err
+	}
+
+	aead, err := kf.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	kf.Data = aead.Seal(nil, kf.Nonce, append(append([]byte{}, k.Data...), k.Id...), nil)
+	return json.MarshalIndent(kf, "", "  ")
+}
+
+// Unwrap decrypts the key stored in a key file with the passphrase.
+func Unwrap(keyfile []byte, passphrase string) (*Key, error) {
+	var kf KeyFile
+	if err := json.Unmarshal(keyfile, &kf); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if kf.Version != 1 || kf.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key file version %d (%s)", kf.Version, kf.KDF)
+	}
+
+	aead, err := kf.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := aead.Open(nil, kf.Nonce, kf.Data, nil)
+	if err != nil || len(buf) != 2*chacha20poly1305.KeySize {
+		return nil, ErrWrongPassword
+	}
+	return &Key{Data: buf[:chacha20poly1305.KeySize], Id: buf[chacha20poly1305.KeySize:]}, nil
+}
+
+func (kf *KeyFile) aead(passphrase string) (cipher.AEAD, error) {
+	kek, err := scrypt.Key([]byte(passphrase), kf.Salt, kf.N, kf.R, kf.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return chacha20poly1305.NewX(kek)
+}
+
+// BlockId maps a content hash to the name used for its block on the destination.
+func (k *Key) BlockId(hash string) string {
+	mac := hmac.New(sha256.New, k.Id)
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,176 @@
+package encryptor
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Streams are split in segments sealed with XChaCha20-Poly1305, the nonce of
+// each segment is a random prefix followed by the segment counter whose top
+// bit flags the last segment, so segments can not be reordered or truncated.
+const (
+	segmentSize = 64 * 1024
+	prefixSize  = chacha20poly1305.NonceSizeX - 8
+	lastSegment = uint64(1) << 63
+)
+
+var magic = []byte("CPV1")
+
+var ErrCorrupted = errors.New("encrypted data is corrupted or the key is wrong")
+
+func nonce(prefix []byte, counter uint64, last bool) []byte {
+	n := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(n, prefix)
+	if last {
+		counter |= lastSegment
+	}
+	binary.BigEndian.PutUint64(n[prefixSize:], counter)
+	return n
+}
+
+type writer struct {
+	aead    cipher.AEAD
+	dst     io.Writer
+	prefix  []byte
+	counter uint64
+	buf     []byte
+	out     []byte
+	closed  bool
+}
+
+// NewWriter encrypts everything written to it into w, Close seals the last
+// segment but does not close w.
+func (k *Key) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	aead, err := chacha20poly1305.NewX(k.Data)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte{}, magic...), prefix...)); err != nil {
+		return nil, err
+	}
+	return &writer{aead: aead, dst: w, prefix: prefix, buf: make([]byte, 0, segmentSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write on closed encryptor")
+	}
+	written := 0
+	for len(p) > 0 {
+		// a full segment is sealed only once more data arrives, the last
+		// segment must be sealed as such on Close
+		if len(w.buf) == segmentSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := min(segmentSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) seal(last bool) error {
+	w.out = w.aead.Seal(w.out[:0], nonce(w.prefix, w.counter, last), w.buf, nil)
+	w.buf = w.buf[:0]
+	w.counter++
+	_, err := w.dst.Write(w.out)
+	return err
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+type reader struct {
+	aead    cipher.AEAD
+	src     *bufio.Reader
+	prefix  []byte
+	counter uint64
+	ct      []byte
+	plain   []byte
+	pending []byte
+	done    bool
+}
+
+// NewReader decrypts a stream written by NewWriter, it fails with
+// ErrCorrupted when the data was altered or truncated.
+func (k *Key) NewReader(r io.Reader) (io.Reader, error) {
+	aead, err := chacha20poly1305.NewX(k.Data)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(magic)+prefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if string(header[:len(magic)]) != string(magic) {
+		return nil, errors.New("data is not encrypted with a repository key")
+	}
+	return &reader{
+		aead:   aead,
+		src:    bufio.NewReader(r),
+		prefix: header[len(magic):],
+		ct:     make([]byte, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *reader) open() error {
+	n, err := io.ReadFull(r.src, r.ct)
+	last := false
+	switch err {
+	case nil:
+		if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		return ErrCorrupted
+	default:
+		return err
+	}
+
+	plain, err := r.aead.Open(r.plain[:0], nonce(r.prefix, r.counter, last), r.ct[:n], nil)
+	if err != nil {
+		return ErrCorrupted
+	}
+	r.plain = plain
+	r.pending = plain
+	r.counter++
+	r.done = last
+	return nil
+}
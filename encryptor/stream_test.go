@@ -0,0 +1,80 @@
+package encryptor
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encrypt(t *testing.T, key *Key, data []byte) []byte {
+	var out bytes.Buffer
+	w, err := key.NewWriter(&out)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return out.Bytes()
+}
+
+func decrypt(key *Key, data []byte) ([]byte, error) {
+	r, err := key.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3 * segmentSize} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+
+		plain, err := decrypt(key, encrypt(t, key, data))
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, data, append([]byte{}, plain...), "size %d", size)
+	}
+}
+
+func TestStreamDetectsTampering(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("capivara"), segmentSize)
+	sealed := encrypt(t, key, data)
+
+	flipped := append([]byte{}, sealed...)
+	flipped[len(flipped)/2] ^= 1
+	_, err = decrypt(key, flipped)
+	assert.ErrorIs(t, err, ErrCorrupted)
+
+	// dropping whole trailing segments must not go unnoticed
+	truncated := sealed[:len(magic)+prefixSize+segmentSize+16]
+	_, err = decrypt(key, truncated)
+	assert.ErrorIs(t, err, ErrCorrupted)
+
+	other, err := NewKey()
+	require.NoError(t, err)
+	_, err = decrypt(other, sealed)
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	keyfile, err := key.Wrap("secret")
+	require.NoError(t, err)
+
+	unwrapped, err := Unwrap(keyfile, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, key, unwrapped)
+	assert.Equal(t, key.BlockId("abc"), unwrapped.BlockId("abc"))
+
+	_, err = Unwrap(keyfile, "wrong")
+	assert.ErrorIs(t, err, ErrWrongPassword)
+}
@@ -1,120 +1,347 @@
 package handlers
 
 import (
+	"database/sql"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"os"
-	"uelei/capivara-sync/compressor"
+	"os/user"
+	"time"
 	"uelei/capivara-sync/db"
 	"uelei/capivara-sync/sources"
 )
 
-func GetRemoteFileName(file sources.FileInfo) string {
-	return "block_" + file.Md5 + ".zst"
+// backupResult is what a worker did with a file, only the collector writes
+// it to the database.
+type backupResult struct {
+	record db.FileRecord
+	reason string
+	blocks []string
+	// uploaded are the blocks of the file uploaded during this run, newBytes
+	// the size of the ones this file uploaded
+	uploaded []db.BlockRecord
+	newBytes int64
+	err      error
+	// resumed files are already in the snapshot being resumed
+	resumed bool
 }
 
-func Backup(origin sources.Source, destination sources.Source, setting sources.Setting) error {
+func Backup(origin sources.Source, destination sources.Source, setting sources.Setting) (err error) {
 
-	database, er := GetDatabaseFromRemote(destination)
-	if er != nil {
-		log.Error("Error getting database from remote:", er)
+	plan := newPlan(setting)
+	var recorder *sources.DryRun
+	open := OpenRepository
+	if setting.DryRun {
+		// the local copy of the database is discarded and the blocks only recorded
+		log.Warn("Dry run, the destination will not be changed")
+		recorder = sources.NewDryRun(destination)
+		destination = recorder
+		open = OpenRepositoryReadOnly
 	}
 
-	defer func() {
-		log.Info("Clean up environment")
-		if err := database.Close(); err != nil {
-			log.Fatal("Error closing database:", err)
-		}
+	repo, er := open(destination, setting)
+	if er != nil {
+		return fmt.Errorf("error opening repository: %w", er)
+	}
+	defer closeRepository(repo, &err)
+	database := repo.Database
 
-		db_file, _ := os.ReadFile("snapshot_files.db")
-		// Move the database file to another folder
-		log.Info("Saving database file to remote storage")
-		saveerr := destination.SaveFile("snapshot_files.db", db_file, "-rw-r--r--")
-		if saveerr != nil {
-			log.Fatal("Error saving database file to local storage:", saveerr)
-		} else {
-			if err := os.Remove("snapshot_files.db"); err != nil {
-				log.Error("Error removing local database file:", err)
-			}
-		}
-	}()
-	snap_id, er := db.SaveSnapshot(database)
+	previous, er := lastManifest(database)
+	if er != nil {
+		return er
+	}
+	start := time.Now()
+	snapshot, recorded, er := startSnapshot(database, setting)
 	if er != nil {
-		log.Fatal("Error saving snapshot:", er)
+		return er
 	}
-	files := origin.ListFiles()
+	snap_id := snapshot.Id
+	lastCheckpoint := start
+	files := listEntries(origin)
 
+	errs := &errorCollector{what: "back up"}
+	seen := map[string]bool{}
 	fmt.Println("Files in folder:")
-	for file := range files {
-		var error, errr error
-
-		log.Debug("File is ", file.Path, " MD5: ", file.Md5, " Filename: ", file.Filename)
-		remote_filename := GetRemoteFileName(file)
-		exists := destination.Exists(remote_filename)
-
-		hf, error := db.GetFileByHash(database, file.Md5)
-		if error != nil {
-			fmt.Println("Error getting file by hash:", error)
+	save := func(result backupResult) {
+		seen[result.record.Path] = true
+		if result.resumed {
+			plan.Add(PlanSkip, result.record.Path, "backed up before the interruption", result.record.Size)
+			return
 		}
-		reason := ""
-		remote_hash := ""
-		upload := true
-		status := "upload"
-		if exists {
-			remote_hash, error = destination.GetFileHash(remote_filename)
-			if error != nil {
-				log.Error("Error getting file hash:", error)
-				// remote_hash = file.RemoteHash
+		// blocks uploaded before a file failed are recorded so they are
+		// reused, the blocks shared with a file collected later are recorded
+		// before this file references them
+		for _, block := range result.uploaded {
+			if err := db.SaveBlock(database, block); err != nil {
+				log.Error("Error saving block to database:", err)
 			}
-		} else {
-			reason = "File does not exist in remote storage."
 		}
-
-		if hf == nil {
-			reason = "file has not been backed up previously."
+		snapshot.NewBytes += result.newBytes
+		if result.err != nil {
+			log.Error("Error backing up file ", result.record.Path, ": ", result.err)
+			errs.Add(result.record.Path, result.err)
+			return
+		}
+		if result.blocks != nil {
+			if err := db.SaveFileChunks(database, result.record.MD5, result.blocks); err != nil {
+				log.Error("Error saving file chunks to database:", err)
+				errs.Add(result.record.Path, err)
+				return
+			}
+		}
+		if err := db.SaveFileInfo(database, result.record); err != nil {
+			log.Error("Error saving file info to database:", err)
+			errs.Add(result.record.Path, err)
 		} else {
-			log.Warn("file exists hash is :", remote_hash, " file hash is: ", hf.RemoteHash)
+			log.Debug("File info saved to database successfully")
+			// a file changed since the interruption replaces its entry
+			if before, ok := recorded[result.record.Path]; ok {
+				if before.Type != sources.EntryDir {
+					snapshot.FileCount--
+				}
+				snapshot.TotalBytes -= before.Size
+			}
+			if result.record.Type != sources.EntryDir {
+				snapshot.FileCount++
+			}
+			snapshot.TotalBytes += result.record.Size
 		}
 
-		if hf != nil && remote_hash != hf.RemoteHash && exists {
-			reason = "Remote file hash does not match."
-			if setting.Skip_hash {
-				upload = false
-				status = "skip"
+		_, known := previous[result.record.Path]
+		switch {
+		case result.record.Status == "entry" && known:
+			plan.Add(PlanSkip, result.record.Path, "already backed up", 0)
+		case result.record.Status == "entry":
+			plan.Add(PlanAdd, result.record.Path, "new "+result.record.Type, 0)
+		case result.record.Status == "skip":
+			plan.Add(PlanSkip, result.record.Path, "already backed up", result.record.Size)
+		case known:
+			plan.Add(PlanUpdate, result.record.Path, result.reason, result.record.Size)
+		default:
+			plan.Add(PlanAdd, result.record.Path, result.reason, result.record.Size)
+		}
+	}
+	runOrdered(setting.Jobs, files, func(file sources.FileInfo) backupResult {
+		if before, ok := recorded[file.Path]; ok && before.MD5 == file.Md5 {
+			return backupResult{record: db.FileRecord{Path: file.Path, Size: file.Size}, resumed: true}
+		}
+		return repo.backupFile(origin, file, snap_id, setting)
+	}, func(result backupResult) {
+		save(result)
+		// the database is saved along the way so an interrupted backup can
+		// be resumed from there, once the result is recorded with its blocks
+		if time.Since(lastCheckpoint) >= checkpointInterval {
+			lastCheckpoint = time.Now()
+			snapshot.Duration += lastCheckpoint.Sub(start)
+			start = lastCheckpoint
+			if err := db.SaveSnapshotStats(database, snapshot); err != nil {
+				log.Error("Error saving snapshot statistics:", err)
 			}
+			if err := repo.Checkpoint(); err != nil {
+				log.Warn("Error saving database checkpoint: ", err)
+			}
+		}
+	})
+
+	// the paths removed from the origin since the interruption are not in
+	// the snapshot anymore
+	for _, before := range recorded {
+		if seen[before.Path] {
+			continue
+		}
+		if err := db.DeleteFileInfo(database, snap_id, before.Path); err != nil {
+			errs.Add(before.Path, err)
+			continue
 		}
+		if before.Type != sources.EntryDir {
+			snapshot.FileCount--
+		}
+		snapshot.TotalBytes -= before.Size
+		plan.Add(PlanDelete, before.Path, "removed since the interruption", before.Size)
+	}
+
+	snapshot.Duration += time.Since(start)
+	if err := db.SaveSnapshotStats(database, snapshot); err != nil {
+		log.Error("Error saving snapshot statistics:", err)
+	}
+	status := db.SnapshotComplete
+	if errs.Err() != nil {
+		status = db.SnapshotFailed
+	}
+	if err := db.SetSnapshotStatus(database, snapshot.Id, status); err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot %d %s: %d files, %s, %s new, took %s\n", snapshot.Id, status, snapshot.FileCount,
+		FormatBytes(snapshot.TotalBytes), FormatBytes(snapshot.NewBytes), snapshot.Duration.Round(time.Millisecond))
+
+	if plan != nil {
+		plan.Print(os.Stdout, recorder.Operations())
+	}
+	return errs.Err()
+}
 
-		if reason != "" && upload {
-			log.Info("Backing up file: ", file.Path, " — reason: ", reason)
-			origin_file_bytes, error := origin.GetFile(file.Path)
-			if error != nil {
-				fmt.Println("Error getting file:", error)
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		log.Warn("Error getting hostname: ", err)
+	}
+	return name
+}
+
+func username() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// checkpointInterval is how often a backup saves the database to the
+// destination.
+var checkpointInterval = 5 * time.Minute
+
+// startSnapshot creates the snapshot the backup fills. With setting.Resume
+// it continues the snapshot of an interrupted backup instead, and returns
+// the files it already holds by path.
+func startSnapshot(database *sql.DB, setting sources.Setting) (db.SnapShotRecord, map[string]db.FileRecord, error) {
+	if setting.Resume {
+		interrupted, err := db.GetInterruptedSnap(database)
+		if err != nil {
+			return db.SnapShotRecord{}, nil, fmt.Errorf("error finding the interrupted snapshot: %w", err)
+		}
+		if interrupted == nil {
+			log.Warn("No interrupted backup to resume, starting a new snapshot")
+		} else {
+			if setting.Origin != "" && interrupted.Origin != "" && interrupted.Origin != setting.Origin {
+				return db.SnapShotRecord{}, nil, fmt.Errorf("interrupted snapshot %d is a backup of %s, not %s", interrupted.Id, interrupted.Origin, setting.Origin)
 			}
-			log.Debug("File size: ", len(origin_file_bytes))
-			var compresedfile []byte
-			compresedfile, errr = compressor.CompressZstd(origin_file_bytes)
-			if errr != nil {
-				fmt.Println("Error compressing file:", errr)
+			files, err := db.ListFilesbySnapshot(database, interrupted.Id)
+			if err != nil {
+				return db.SnapShotRecord{}, nil, fmt.Errorf("error listing files of snapshot %d: %w", interrupted.Id, err)
 			}
-			remote_hash, error = destination.CalculateFileHash(compresedfile)
-			if error != nil {
-				log.Error("Error calculating file hash:", error)
+			recorded := make(map[string]db.FileRecord, len(files))
+			for _, file := range files {
+				recorded[file.Path] = file
 			}
-			log.Debug(" size of file: ", len(compresedfile))
-			log.Info("Writing file to remote:", remote_filename)
-			if err := destination.SaveFile(remote_filename, compresedfile, "-rw-r--r--"); err != nil {
-				log.Error("Error saving file to remote storage:", err)
-			} else {
-				log.Debug("File saved to remote storage successfully")
+			if err := db.SetSnapshotStatus(database, interrupted.Id, db.SnapshotInProgress); err != nil {
+				return db.SnapShotRecord{}, nil, err
 			}
+			log.Info("Resuming snapshot ", interrupted.Id, " of ", interrupted.Date, ", ", len(files), " files already backed up")
+			return *interrupted, recorded, nil
 		}
+	}
 
-		if err := db.SaveFileInfo(database, file.Path, file.Md5, file.Permission, int(snap_id), remote_hash, status); err != nil {
-			log.Error("Error saving file info to database:", err)
-		} else {
-			log.Debug("File info saved to database successfully")
+	snapshot := db.SnapShotRecord{
+		Hostname:    hostname(),
+		User:        username(),
+		Origin:      setting.Origin,
+		Tags:        setting.Tags,
+		Description: setting.Description,
+	}
+	id, err := db.SaveSnapshot(database, snapshot)
+	if err != nil {
+		return db.SnapShotRecord{}, nil, fmt.Errorf("error saving snapshot: %w", err)
+	}
+	snapshot.Id = int(id)
+	return snapshot, nil, nil
+}
+
+// listEntries lists every entry of a source able to, only the files
+// otherwise.
+func listEntries(source sources.Source) <-chan sources.FileInfo {
+	if recorder, ok := source.(*sources.DryRun); ok {
+		source = recorder.Source
+	}
+	if lister, ok := source.(sources.EntryLister); ok {
+		return lister.ListEntries()
+	}
+	return source.ListFiles()
+}
+
+// lastManifest maps the paths of the last snapshot to their content hash.
+func lastManifest(database *sql.DB) (map[string]string, error) {
+	manifest := map[string]string{}
+	last, err := db.GetLastSnap(database)
+	if err != nil || last == nil {
+		return manifest, err
+	}
+	files, err := db.ListFilesbySnapshot(database, last.Id)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files of snapshot %d: %w", last.Id, err)
+	}
+	for _, file := range files {
+		manifest[file.Path] = file.MD5
+	}
+	return manifest, nil
+}
+
+// backupFile uploads the blocks of the file the destination is missing.
+func (r *Repository) backupFile(origin sources.Source, file sources.FileInfo, snap_id int, setting sources.Setting) backupResult {
+	log.Debug("File is ", file.Path, " MD5: ", file.Md5, " Filename: ", file.Filename)
+	result := backupResult{record: db.FileRecord{
+		Path:       file.Path,
+		MD5:        file.Md5,
+		Permission: file.Permission,
+		SnapId:     snap_id,
+		Size:       file.Size,
+		Modified:   file.LastModified,
+		Status:     "skip",
+		Mode:       file.Mode,
+		Accessed:   file.Accessed,
+		Owner:      (*db.Owner)(file.Owner),
+		Type:       file.Type,
+		LinkTarget: file.LinkTarget,
+		Device:     file.Device,
+		Xattrs:     file.Xattrs,
+	}}
+	if file.Type != "" && file.Type != sources.EntryFile {
+		// a hard link shares the blocks of the file it links to
+		result.record.Status = "entry"
+		return result
+	}
+
+	blocks, error := db.GetFileChunks(r.Database, file.Md5)
+	if error != nil {
+		log.Error("Error getting file chunks:", error)
+	}
+	reason := ""
+	if len(blocks) == 0 {
+		reason = "file has not been backed up previously."
+	}
+	for _, hash := range blocks {
+		if reason = r.blockReason(hash, setting); reason != "" {
+			break
 		}
 	}
+	if reason == "" {
+		return result
+	}
 
-	return nil
+	log.Info("Backing up file: ", file.Path, " — reason: ", reason)
+	result.record.Status = "upload"
+	result.reason = reason
+	if setting.DryRun {
+		// the plan reports the file with its size, reading and storing its
+		// blocks would cost almost as much as the backup
+		return result
+	}
+	origin_file, error := origin.OpenFile(file.Path)
+	if error != nil {
+		result.err = fmt.Errorf("error getting file: %w", error)
+		return result
+	}
+	defer origin_file.Close()
+
+	log.Debug("File size: ", file.Size)
+	blocks, md5sum, uploaded, newBytes, error := r.backupChunks(origin_file, setting)
+	result.uploaded, result.newBytes = uploaded, newBytes
+	if error != nil {
+		result.err = error
+		return result
+	}
+	if md5sum != file.Md5 {
+		log.Warn("File changed while backing up: ", file.Path)
+		result.record.MD5 = md5sum
+	}
+	result.blocks = blocks
+	return result
 }
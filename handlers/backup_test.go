@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySource fails to open one file and records the files opened.
+type flakySource struct {
+	sources.Source
+	fail   string
+	mu     sync.Mutex
+	opened []string
+}
+
+func (f *flakySource) OpenFile(path string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	f.opened = append(f.opened, path)
+	f.mu.Unlock()
+	if path == f.fail {
+		return nil, errors.New("disk error")
+	}
+	return f.Source.OpenFile(path)
+}
+
+func snapshots(t *testing.T, destination sources.Source) []db.SnapShotRecord {
+	repo, err := OpenRepositoryReadOnly(destination, sources.Setting{})
+	require.NoError(t, err)
+	defer repo.Close()
+	snaps, err := db.ListSnapShots(repo.Database)
+	require.NoError(t, err)
+	return snaps
+}
+
+func TestBackupResume(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0644))
+	}
+	origin := sources.NewLocalsource(dir)
+	destination := sources.NewLocalsource(t.TempDir())
+	setting := sources.Setting{Compress: true, Jobs: 2, Origin: dir}
+
+	flaky := &flakySource{Source: origin, fail: "b.txt"}
+	assert.Error(t, Backup(flaky, destination, setting))
+	snaps := snapshots(t, destination)
+	require.Len(t, snaps, 1)
+	assert.Equal(t, db.SnapshotFailed, snaps[0].Status)
+	assert.Equal(t, int64(2), snaps[0].FileCount)
+
+	repo, err := OpenRepositoryReadOnly(destination, sources.Setting{})
+	require.NoError(t, err)
+	last, err := db.GetLastSnap(repo.Database)
+	repo.Close()
+	require.NoError(t, err)
+	assert.Nil(t, last, "a failed snapshot is not restored")
+
+	setting.Resume = true
+	flaky = &flakySource{Source: origin}
+	require.NoError(t, Backup(flaky, destination, setting))
+	assert.Equal(t, []string{"b.txt"}, flaky.opened)
+
+	snaps = snapshots(t, destination)
+	require.Len(t, snaps, 1)
+	assert.Equal(t, db.SnapshotComplete, snaps[0].Status)
+	assert.Equal(t, int64(3), snaps[0].FileCount)
+
+	// nothing is left to resume, a new snapshot is taken
+	require.NoError(t, Backup(origin, destination, setting))
+	snaps = snapshots(t, destination)
+	require.Len(t, snaps, 2)
+	assert.Equal(t, db.SnapshotComplete, snaps[1].Status)
+}
+
+func TestBackupResumeOtherOrigin(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+	destination := sources.NewLocalsource(t.TempDir())
+
+	flaky := &flakySource{Source: sources.NewLocalsource(dir), fail: "a.txt"}
+	assert.Error(t, Backup(flaky, destination, sources.Setting{Jobs: 1, Origin: dir}))
+
+	err := Backup(sources.NewLocalsource(dir), destination, sources.Setting{Jobs: 1, Origin: "/elsewhere", Resume: true})
+	assert.ErrorContains(t, err, "is a backup of")
+}
+
+func TestBackupResumeChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0644))
+	}
+	origin := sources.NewLocalsource(dir)
+	destination := sources.NewLocalsource(t.TempDir())
+	setting := sources.Setting{Jobs: 1, Origin: dir}
+
+	assert.Error(t, Backup(&flakySource{Source: origin, fail: "b.txt"}, destination, setting))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a.txt changed since"), 0644))
+
+	setting.Resume = true
+	require.NoError(t, Backup(origin, destination, setting))
+	snaps := snapshots(t, destination)
+	require.Len(t, snaps, 1)
+	assert.Equal(t, int64(2), snaps[0].FileCount)
+	assert.Equal(t, int64(len("a.txt changed since")+len("content of b.txt")), snaps[0].TotalBytes)
+}
+
+func TestBackupResumeDropsRemovedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0644))
+	}
+	origin := sources.NewLocalsource(dir)
+	destination := sources.NewLocalsource(t.TempDir())
+	setting := sources.Setting{Jobs: 1, Origin: dir}
+
+	assert.Error(t, Backup(&flakySource{Source: origin, fail: "b.txt"}, destination, setting))
+	require.NoError(t, os.Remove(filepath.Join(dir, "a.txt")))
+
+	setting.Resume = true
+	require.NoError(t, Backup(origin, destination, setting))
+	snaps := snapshots(t, destination)
+	require.Len(t, snaps, 1)
+	assert.Equal(t, int64(2), snaps[0].FileCount)
+	assert.Equal(t, int64(len("content of b.txt")+len("content of c.txt")), snaps[0].TotalBytes)
+
+	repo, err := OpenRepositoryReadOnly(destination, sources.Setting{})
+	require.NoError(t, err)
+	defer repo.Close()
+	files, err := db.ListFilesbySnapshot(repo.Database, snaps[0].Id)
+	require.NoError(t, err)
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	assert.Equal(t, []string{"b.txt", "c.txt"}, paths)
+}
+
+// slowSource opens one file late, after the other workers are done.
+type slowSource struct {
+	sources.Source
+	slow string
+}
+
+func (s slowSource) OpenFile(path string) (io.ReadCloser, error) {
+	if path == s.slow {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return s.Source.OpenFile(path)
+}
+
+// checkpoints counts the chunks referencing unrecorded blocks in every
+// database saved to the destination.
+type checkpoints struct {
+	sources.Localsource
+	t        *testing.T
+	saved    int
+	dangling int
+}
+
+func (c *checkpoints) RenameFile(from, to string) error {
+	if err := c.Localsource.RenameFile(from, to); err != nil || to != DatabaseFileName {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(c.Localpath, to))
+	require.NoError(c.t, err)
+	copied := filepath.Join(c.t.TempDir(), to)
+	require.NoError(c.t, os.WriteFile(copied, data, 0600))
+	database, err := db.InitDB(copied)
+	require.NoError(c.t, err)
+	defer database.Close()
+	var dangling int
+	require.NoError(c.t, database.QueryRow(`SELECT count(*) FROM file_chunks WHERE block_hash NOT IN (SELECT hash FROM blocks)`).Scan(&dangling))
+	c.saved++
+	c.dangling += dangling
+	return nil
+}
+
+func TestBackupCheckpointRecordsSharedBlocks(t *testing.T) {
+	interval := checkpointInterval
+	checkpointInterval = 0
+	defer func() { checkpointInterval = interval }()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("the same content"), 0644))
+	}
+	// b.txt uploads the block a.txt shares, a.txt is collected first
+	destination := &checkpoints{Localsource: sources.NewLocalsource(t.TempDir()), t: t}
+	origin := slowSource{Source: sources.NewLocalsource(dir), slow: "a.txt"}
+	require.NoError(t, Backup(origin, destination, sources.Setting{Jobs: 2}))
+	assert.Greater(t, destination.saved, 1)
+	assert.Zero(t, destination.dangling)
+}
+
+func TestBackupDryRunReadsNothing(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("content of a.txt"), 0644))
+	destination := sources.NewLocalsource(t.TempDir())
+
+	origin := &flakySource{Source: sources.NewLocalsource(dir)}
+	require.NoError(t, Backup(origin, destination, sources.Setting{Jobs: 1, DryRun: true}))
+	assert.Empty(t, origin.opened)
+	names, err := destination.ListNames()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
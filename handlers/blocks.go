@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"uelei/capivara-sync/chunker"
+	"uelei/capivara-sync/compressor"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GetRemoteFileName returns the name of the block holding the chunk hash
+// compressed with codec, in encrypted repositories the name is keyed so it
+// does not reveal the hash.
+func (r *Repository) GetRemoteFileName(hash string, codec string) string {
+	extension := ".zst"
+	if c, err := compressor.GetCodec(codec); err == nil {
+		extension = c.Extension()
+	}
+	if r.Key != nil {
+		return "block_" + r.Key.BlockId(hash) + extension
+	}
+	return "block_" + hash + extension
+}
+
+// blockFileName is the name of a block recorded in the database.
+func (r *Repository) blockFileName(hash string) string {
+	codec := compressor.Zstd
+	if block, err := db.GetBlock(r.Database, hash); err == nil && block != nil {
+		codec = block.Codec
+	}
+	return r.GetRemoteFileName(hash, codec)
+}
+
+func HashBytes(data []byte) string {
+	hash := md5.Sum(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// verifyBlock returns why the block has to be uploaded again, or "" when the
+// destination already holds it.
+func (r *Repository) verifyBlock(hash string, setting sources.Setting) string {
+	block, err := db.GetBlock(r.Database, hash)
+	if err != nil {
+		log.Error("Error getting block:", err)
+	}
+	if block == nil {
+		return "block has not been backed up previously."
+	}
+
+	remote_filename := r.GetRemoteFileName(hash, block.Codec)
+	if !r.Destination.Exists(remote_filename) {
+		return "Block does not exist in remote storage."
+	}
+	if setting.Skip_hash {
+		return ""
+	}
+
+	remote_hash, err := r.Destination.GetFileHash(remote_filename)
+	if err != nil {
+		log.Error("Error getting file hash:", err)
+	}
+	if remote_hash != block.RemoteHash {
+		log.Warn("block exists hash is :", remote_hash, " recorded hash is: ", block.RemoteHash)
+		return "Remote block hash does not match."
+	}
+	return ""
+}
+
+// blockReason is verifyBlock checking each block once per run.
+func (r *Repository) blockReason(hash string, setting sources.Setting) string {
+	if reason, ok := r.verified.Load(hash); ok {
+		return reason.(string)
+	}
+	reason := r.verifyBlock(hash, setting)
+	r.verified.Store(hash, reason)
+	return reason
+}
+
+// storeBlock compresses and encrypts a chunk and writes it to the destination,
+// chunks are at most chunker.MaxSize so they are kept in memory. Chunks that
+// do not shrink, like already compressed files, are stored uncompressed.
+func (r *Repository) storeBlock(hash string, chunk []byte) (*db.BlockRecord, error) {
+	codec := r.codec
+	compresedfile, err := codec.Compress(chunk)
+	if err != nil {
+		return nil, fmt.Errorf("error compressing block: %w", err)
+	}
+	if len(compresedfile) >= len(chunk) && codec != compressor.Uncompressed {
+		log.Debug("Block ", hash, " does not shrink with ", codec.Name(), ", storing it uncompressed")
+		codec = compressor.Uncompressed
+		compresedfile = chunk
+	}
+
+	var stored bytes.Buffer
+	writer, err := r.encrypt(&stored)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(compresedfile); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	remote_hash, err := r.Destination.CalculateFileHash(stored.Bytes())
+	if err != nil {
+		log.Error("Error calculating file hash:", err)
+	}
+
+	remote_filename := r.GetRemoteFileName(hash, codec.Name())
+	log.Debug("Writing block to remote:", remote_filename, " size: ", stored.Len())
+	if err := r.Destination.SaveFile(remote_filename, stored.Bytes(), "-rw-r--r--"); err != nil {
+		return nil, fmt.Errorf("error saving block to remote storage: %w", err)
+	}
+
+	return &db.BlockRecord{Hash: hash, RemoteHash: remote_hash, Size: int64(len(chunk)), StoredSize: int64(stored.Len()), Codec: codec.Name()}, nil
+}
+
+// backupChunks splits the content in chunks and uploads the ones the
+// destination is missing. It returns the ordered block list, the MD5 of the
+// whole content, the blocks of the content uploaded during this run, which
+// are not yet recorded in the database, and the size of the ones this call
+// uploaded.
+func (r *Repository) backupChunks(content io.Reader, setting sources.Setting) ([]string, string, []db.BlockRecord, int64, error) {
+	var blocks []string
+	var uploaded []db.BlockRecord
+	var newBytes int64
+	store := func(chunk []byte) error {
+		blocks = append(blocks, HashBytes(chunk))
+		block, stored, err := r.backupChunk(chunk, setting)
+		if block != nil {
+			uploaded = append(uploaded, *block)
+		}
+		if stored {
+			newBytes += block.StoredSize
+		}
+		return err
+	}
+
+	filehash := md5.New()
+	chunks := chunker.New(io.TeeReader(content, filehash))
+	for {
+		chunk, err := chunks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = store(chunk)
+		}
+		if err != nil {
+			return nil, "", uploaded, newBytes, err
+		}
+	}
+
+	// empty files are kept as a single empty block
+	if len(blocks) == 0 {
+		if err := store(nil); err != nil {
+			return nil, "", uploaded, newBytes, err
+		}
+	}
+	return blocks, hex.EncodeToString(filehash.Sum(nil)), uploaded, newBytes, nil
+}
+
+// blockUpload makes sure concurrent backups of the same chunk upload it once.
+type blockUpload struct {
+	once  sync.Once
+	block *db.BlockRecord
+	err   error
+}
+
+// backupChunk uploads the chunk unless the destination holds it. It returns
+// the block when it was uploaded during this run, by this call or a
+// concurrent one, and whether this call uploaded it. A failed upload is
+// forgotten so the next file holding the chunk tries again.
+func (r *Repository) backupChunk(chunk []byte, setting sources.Setting) (*db.BlockRecord, bool, error) {
+	hash := HashBytes(chunk)
+	value, _ := r.uploads.LoadOrStore(hash, &blockUpload{})
+	upload := value.(*blockUpload)
+
+	stored := false
+	upload.once.Do(func() {
+		reason := r.blockReason(hash, setting)
+		if reason == "" {
+			return
+		}
+		log.Debug("Uploading block: ", hash, " — reason: ", reason)
+		upload.block, upload.err = r.storeBlock(hash, chunk)
+		if upload.err != nil {
+			r.uploads.CompareAndDelete(hash, upload)
+			return
+		}
+		stored = true
+		r.verified.Store(hash, "")
+	})
+	return upload.block, stored, upload.err
+}
+
+// restoreContent writes a file content reassembled from its blocks to w.
+func (r *Repository) restoreContent(fileMd5 string, w io.Writer) error {
+	blocks, err := db.GetFileChunks(r.Database, fileMd5)
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		return fmt.Errorf("no blocks recorded for content %s", fileMd5)
+	}
+
+	filehash := md5.New()
+	w = io.MultiWriter(w, filehash)
+	for _, hash := range blocks {
+		if err := r.copyBlock(hash, w); err != nil {
+			return fmt.Errorf("error restoring block %s: %w", hash, err)
+		}
+	}
+
+	if hash := hex.EncodeToString(filehash.Sum(nil)); hash != fileMd5 {
+		return fmt.Errorf("restored content hash %s does not match %s", hash, fileMd5)
+	}
+	return nil
+}
+
+func (r *Repository) copyBlock(hash string, w io.Writer) error {
+	record, err := db.GetBlock(r.Database, hash)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return fmt.Errorf("block %s is not recorded", hash)
+	}
+	codec, err := compressor.GetCodec(record.Codec)
+	if err != nil {
+		return err
+	}
+
+	block, err := r.Destination.OpenFile(r.GetRemoteFileName(hash, record.Codec))
+	if err != nil {
+		return err
+	}
+	defer block.Close()
+
+	decrypted, err := r.decrypt(block)
+	if err != nil {
+		return err
+	}
+	chunk, err := codec.NewReader(decrypted)
+	if err != nil {
+		return err
+	}
+	defer chunk.Close()
+
+	_, err = io.Copy(w, chunk)
+	return err
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreBlockCodecs(t *testing.T) {
+	destination := sources.Localsource{Localpath: t.TempDir() + "/"}
+	repo, err := OpenRepositoryReadOnly(destination, sources.Setting{Compress: true, Codec: "gzip:9"})
+	require.NoError(t, err)
+	defer repo.Close()
+
+	compressible := bytes.Repeat([]byte("0123456789"), 10000)
+	random := make([]byte, 100000)
+	_, err = rand.Read(random)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		chunk []byte
+		codec string
+		name  string
+	}{
+		{compressible, "gzip", "block_" + HashBytes(compressible) + ".gz"},
+		// data that does not shrink is stored as is
+		{random, "none", "block_" + HashBytes(random)},
+	} {
+		hash := HashBytes(c.chunk)
+		block, err := repo.storeBlock(hash, c.chunk)
+		require.NoError(t, err)
+		assert.Equal(t, c.codec, block.Codec)
+		assert.True(t, destination.Exists(c.name), c.name)
+		require.NoError(t, db.SaveBlock(repo.Database, *block))
+
+		var restored bytes.Buffer
+		require.NoError(t, repo.copyBlock(hash, &restored))
+		assert.Equal(t, c.chunk, restored.Bytes())
+	}
+}
+
+// failingSaves fails the first saves of files.
+type failingSaves struct {
+	sources.Localsource
+	failures int
+}
+
+func (f *failingSaves) SaveFile(path string, data []byte, permission string) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("connection reset")
+	}
+	return f.Localsource.SaveFile(path, data, permission)
+}
+
+func TestBackupChunkRetriesFailedUpload(t *testing.T) {
+	destination := &failingSaves{Localsource: sources.NewLocalsource(t.TempDir()), failures: 1}
+	repo, err := OpenRepositoryReadOnly(destination, sources.Setting{})
+	require.NoError(t, err)
+	defer repo.Close()
+
+	chunk := []byte("shared chunk")
+	_, _, err = repo.backupChunk(chunk, sources.Setting{})
+	assert.ErrorContains(t, err, "connection reset")
+	block, stored, err := repo.backupChunk(chunk, sources.Setting{})
+	require.NoError(t, err)
+	require.NotNil(t, block)
+	assert.True(t, stored)
+	assert.True(t, destination.Exists("block_"+HashBytes(chunk)))
+
+	// a block uploaded is not uploaded again, the files sharing it still
+	// get it to record before referencing it
+	shared, stored, err := repo.backupChunk(chunk, sources.Setting{})
+	require.NoError(t, err)
+	assert.False(t, stored)
+	assert.Equal(t, block, shared)
+}
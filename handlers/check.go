@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckResult lists the problems found by Check, blocks are named as on the
+// destination.
+type CheckResult struct {
+	Snapshots int
+	Files     int
+	Blocks    int
+	Read      int
+	Missing   []string
+	Corrupt   []string
+	Orphaned  []string
+}
+
+// Ok reports whether every snapshot can be restored, orphaned blocks only
+// waste space.
+func (c *CheckResult) Ok() bool {
+	return len(c.Missing) == 0 && len(c.Corrupt) == 0
+}
+
+// Check verifies every block referenced by a snapshot is on the destination
+// with the recorded hash. readData is the percentage of those blocks also
+// downloaded and compared with the content hash, 0 skips reading.
+func Check(destination sources.Source, readData float64, setting sources.Setting) (result *CheckResult, err error) {
+	repo, err := OpenRepositoryShared(destination, setting)
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository: %w", err)
+	}
+	defer closeRepository(repo, &err)
+
+	result = &CheckResult{}
+	referenced, err := repo.referencedBlocks(result)
+	if err != nil {
+		return nil, err
+	}
+
+	var present []string
+	for _, hash := range referenced {
+		if problem := repo.checkBlock(hash); problem != "" {
+			log.Error("Block ", hash, ": ", problem)
+			if strings.HasPrefix(problem, "missing") {
+				result.Missing = append(result.Missing, repo.blockFileName(hash))
+			} else {
+				result.Corrupt = append(result.Corrupt, repo.blockFileName(hash))
+			}
+			continue
+		}
+		present = append(present, hash)
+	}
+
+	if readData > 0 {
+		rand.Shuffle(len(present), func(i, j int) { present[i], present[j] = present[j], present[i] })
+		count := int(float64(len(present))*readData/100 + 0.5)
+		if count == 0 && len(present) > 0 {
+			count = 1
+		}
+		for _, hash := range present[:min(count, len(present))] {
+			result.Read++
+			if err := repo.readBlock(hash); err != nil {
+				log.Error("Block ", hash, ": ", err)
+				result.Corrupt = append(result.Corrupt, repo.blockFileName(hash))
+			}
+		}
+	}
+
+	names := map[string]bool{}
+	for _, hash := range referenced {
+		names[repo.blockFileName(hash)] = true
+	}
+	stored, err := listNames(destination)
+	if err != nil {
+		return nil, fmt.Errorf("error listing the blocks of the destination: %w", err)
+	}
+	for _, name := range stored {
+		if strings.HasPrefix(name, "block_") && !names[name] {
+			result.Orphaned = append(result.Orphaned, name)
+		}
+	}
+
+	sort.Strings(result.Missing)
+	sort.Strings(result.Corrupt)
+	sort.Strings(result.Orphaned)
+	return result, nil
+}
+
+// listNames lists the paths of the files of a source, without hashing them
+// when the source can.
+func listNames(source sources.Source) ([]string, error) {
+	if lister, ok := source.(sources.NameLister); ok {
+		return lister.ListNames()
+	}
+	var names []string
+	for file := range source.ListFiles() {
+		names = append(names, file.Path)
+	}
+	return names, nil
+}
+
+// referencedBlocks returns the blocks used by any snapshot, contents without
+// blocks are reported as missing.
+func (r *Repository) referencedBlocks(result *CheckResult) ([]string, error) {
+	snaps, err := db.ListSnapShots(r.Database)
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %w", err)
+	}
+	result.Snapshots = len(snaps)
+
+	contents := map[string]bool{}
+	seen := map[string]bool{}
+	var referenced []string
+	for _, snp := range snaps {
+		files, err := db.ListFilesbySnapshot(r.Database, snp.Id)
+		if err != nil {
+			return nil, fmt.Errorf("error listing files of snapshot %d: %w", snp.Id, err)
+		}
+		result.Files += len(files)
+
+		for _, file := range files {
+			if !sources.HasContent(file.Type) || contents[file.MD5] {
+				continue
+			}
+			contents[file.MD5] = true
+
+			blocks, err := db.GetFileChunks(r.Database, file.MD5)
+			if err != nil {
+				return nil, err
+			}
+			if len(blocks) == 0 {
+				log.Error("File ", file.Path, " of snapshot ", snp.Id, " has no blocks recorded")
+				result.Missing = append(result.Missing, "content of "+file.Path)
+			}
+			for _, hash := range blocks {
+				if !seen[hash] {
+					seen[hash] = true
+					referenced = append(referenced, hash)
+				}
+			}
+		}
+	}
+	result.Blocks = len(referenced)
+	return referenced, nil
+}
+
+// checkBlock returns what is wrong with a block on the destination, or "".
+func (r *Repository) checkBlock(hash string) string {
+	block, err := db.GetBlock(r.Database, hash)
+	if err != nil {
+		return "error reading block record: " + err.Error()
+	}
+	if block == nil {
+		return "missing from the database"
+	}
+
+	remote_filename := r.GetRemoteFileName(hash, block.Codec)
+	if !r.Destination.Exists(remote_filename) {
+		return "missing from remote storage"
+	}
+	if block.RemoteHash == "" {
+		return ""
+	}
+	remote_hash, err := r.Destination.GetFileHash(remote_filename)
+	if err != nil {
+		return "error getting remote hash: " + err.Error()
+	}
+	if remote_hash != block.RemoteHash {
+		return fmt.Sprintf("remote hash %s does not match recorded %s", remote_hash, block.RemoteHash)
+	}
+	return ""
+}
+
+// readBlock downloads a block and compares its content with its hash.
+func (r *Repository) readBlock(hash string) error {
+	content := md5.New()
+	if err := r.copyBlock(hash, content); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(content.Sum(nil)); sum != hash {
+		return fmt.Errorf("content hash %s does not match", sum)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkedRepository backs up two files to a new destination and returns it
+// with its block files.
+func checkedRepository(t *testing.T, setting sources.Setting) (sources.Localsource, []string) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "alpha", "b.txt": "beta"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	destination := sources.NewLocalsource(t.TempDir())
+	setting.Jobs = 1
+	require.NoError(t, Backup(sources.NewLocalsource(dir), destination, setting))
+	blocks, err := filepath.Glob(filepath.Join(destination.Localpath, "block_*"))
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+	return destination, blocks
+}
+
+func TestCheck(t *testing.T) {
+	destination, blocks := checkedRepository(t, sources.Setting{Compress: true, Codec: "zstd"})
+	result, err := Check(destination, 100, sources.Setting{})
+	require.NoError(t, err)
+	assert.True(t, result.Ok())
+	assert.Equal(t, 2, result.Blocks)
+	assert.Equal(t, 2, result.Read)
+	assert.Empty(t, result.Orphaned)
+
+	require.NoError(t, os.Remove(blocks[0]))
+	require.NoError(t, os.WriteFile(filepath.Join(destination.Localpath, "block_0123.zst"), []byte("unused"), 0644))
+	result, err = Check(destination, 0, sources.Setting{})
+	require.NoError(t, err)
+	assert.False(t, result.Ok())
+	assert.Equal(t, []string{filepath.Base(blocks[0])}, result.Missing)
+	assert.Empty(t, result.Corrupt)
+	assert.Equal(t, []string{"block_0123.zst"}, result.Orphaned)
+}
+
+func TestCheckCorruptBlock(t *testing.T) {
+	setting := sources.Setting{Encrypt: true, Password: "secret"}
+	destination, blocks := checkedRepository(t, setting)
+	data, err := os.ReadFile(blocks[1])
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(blocks[1], data, 0644))
+
+	result, err := Check(destination, 0, setting)
+	require.NoError(t, err)
+	assert.False(t, result.Ok())
+	assert.Equal(t, []string{filepath.Base(blocks[1])}, result.Corrupt)
+
+	// the content read fails the authentication of the encrypted block
+	repo, err := OpenRepositoryReadOnly(destination, setting)
+	require.NoError(t, err)
+	defer repo.Close()
+	referenced, err := repo.referencedBlocks(&CheckResult{})
+	require.NoError(t, err)
+	failed := 0
+	for _, hash := range referenced {
+		if repo.readBlock(hash) != nil {
+			failed++
+			assert.Equal(t, filepath.Base(blocks[1]), repo.blockFileName(hash))
+		}
+	}
+	assert.Equal(t, 1, failed)
+}
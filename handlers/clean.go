@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTrash is the directory of the restore target the files removed by
+// a clean are moved to, the listings of the sources leave it out.
+const DefaultTrash = sources.TrashDir
+
+// cleanCandidates returns the entries of the restore target a clean
+// removes: the paths the restored entries do not have, within the restore
+// selection, and the directories holding none of them. The trash
+// directories are left alone, the default one even when the files are
+// deleted.
+func cleanCandidates(origin sources.Source, files []db.FileRecord, setting sources.Setting) []sources.FileInfo {
+	restored := make(map[string]bool, len(files))
+	for _, file := range files {
+		name := cleanPath(file.Path)
+		restored[name] = true
+		// the snapshots without directory entries still hold their files
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			restored[dir] = true
+		}
+	}
+	trash := strings.Trim(setting.Trash, "/")
+
+	var extraneous []sources.FileInfo
+	for file := range listEntries(origin) {
+		name := cleanPath(file.Path)
+		if restored[name] || inDir(DefaultTrash, name) || (trash != "" && inDir(trash, name)) {
+			continue
+		}
+		// a partial restore only cleans what it selects
+		if !inRestoreScope(file.Path, setting) {
+			continue
+		}
+		extraneous = append(extraneous, file)
+	}
+	sort.Slice(extraneous, func(i, j int) bool { return extraneous[i].Path < extraneous[j].Path })
+	return extraneous
+}
+
+// cleanPath is the slash separated form of a source path the manifests and
+// listings of a source are compared with.
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+}
+
+// confirmClean asks before a clean removes more than setting.CleanLimit
+// files, a dry run removes nothing and never asks.
+func confirmClean(count int, setting sources.Setting) error {
+	if setting.DryRun || setting.CleanLimit <= 0 || count <= setting.CleanLimit {
+		return nil
+	}
+	refused := fmt.Errorf("the clean would remove %d files, more than the limit of %d: confirm it or raise the limit", count, setting.CleanLimit)
+	if setting.Prompt == nil {
+		return refused
+	}
+	answer, err := setting.Prompt(fmt.Sprintf("The clean would remove %d files not in the snapshot, continue? [y/N] ", count), false)
+	if errors.Is(err, sources.ErrNoPrompt) {
+		return refused
+	}
+	if err != nil {
+		return fmt.Errorf("error confirming the clean: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("clean of %d files cancelled, nothing was restored", count)
+}
+
+// cleanFiles moves the extraneous files to the trash, or removes them when
+// there is none, then removes the directories they leave empty.
+func cleanFiles(origin sources.Source, extraneous []sources.FileInfo, setting sources.Setting, plan *Plan, errs *errorCollector) {
+	trash := ""
+	if setting.Trash != "" {
+		trash = path.Join(strings.Trim(setting.Trash, "/"), time.Now().Format("20060102-150405"))
+	}
+	removed := 0
+	dirs := map[string]bool{}
+	for _, file := range extraneous {
+		if file.Type == sources.EntryDir {
+			// removed once the files in it are
+			plan.Add(PlanDelete, file.Path, "not in the snapshot", 0)
+			dirs[cleanPath(file.Path)] = true
+			continue
+		}
+		if trash == "" {
+			plan.Add(PlanDelete, file.Path, "not in the snapshot", file.Size)
+		} else {
+			plan.Add(PlanDelete, file.Path, "not in the snapshot, moved to "+trash, file.Size)
+		}
+		if err := removeFile(origin, file, trash); err != nil {
+			log.Error("Error removing ", file.Path, ": ", err)
+			errs.Add(file.Path, err)
+			continue
+		}
+		log.Warn("Removed the file not in the snapshot: ", file.Path)
+		removed++
+		for dir := path.Dir(cleanPath(file.Path)); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	// a dry run cannot tell which directories would be left empty
+	if !setting.DryRun {
+		pruneDirs(origin, dirs)
+	}
+	if removed > 0 && trash != "" && !setting.DryRun {
+		if strings.Trim(setting.Trash, "/") != DefaultTrash {
+			ignoreTrash(origin, setting.Trash)
+		}
+		fmt.Printf("Moved %d files not in the snapshot to %s\n", removed, trash)
+	}
+}
+
+// ignoreTrash keeps the files of a trash other than the default one out of
+// the backups of the origin with an ignore file excluding everything in it.
+func ignoreTrash(origin sources.Source, trash string) {
+	ignore := path.Join(strings.Trim(trash, "/"), sources.IgnoreFileName)
+	if origin.Exists(ignore) {
+		return
+	}
+	if err := origin.SaveFile(ignore, []byte("*\n"), "-rw-r--r--"); err != nil {
+		log.Warn("Error excluding the trash from backups: ", err)
+	}
+}
+
+// removeFile moves a file to the trash directory, copying it when the
+// source cannot move it there, or removes it when trash is empty.
+func removeFile(origin sources.Source, file sources.FileInfo, trash string) error {
+	if trash == "" {
+		return origin.RemoveFile(file.Path)
+	}
+	target := path.Join(trash, cleanPath(file.Path))
+	if err := origin.RenameFile(file.Path, target); err == nil {
+		return nil
+	}
+
+	reader, err := origin.OpenFile(file.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer, err := origin.CreateFile(target, file.Permission)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", target, err)
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return fmt.Errorf("error copying to %s: %w", target, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error copying to %s: %w", target, err)
+	}
+	return origin.RemoveFile(file.Path)
+}
+
+// pruneDirs removes the directories left empty, the deepest first so their
+// parents can be removed after them. The ones still holding files stay.
+func pruneDirs(origin sources.Source, dirs map[string]bool) {
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if depth := strings.Count(sorted[i], "/") - strings.Count(sorted[j], "/"); depth != 0 {
+			return depth > 0
+		}
+		return sorted[i] > sorted[j]
+	})
+	for _, dir := range sorted {
+		if err := origin.RemoveDir(dir); err != nil {
+			log.Debug("Keeping directory ", dir, ": ", err)
+			continue
+		}
+		log.Info("Removed empty directory ", dir)
+	}
+}
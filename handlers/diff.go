@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+)
+
+// Changes of a diff entry.
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+	// DiffMetadata files have the same content with another mode or mtime.
+	DiffMetadata = "metadata"
+)
+
+type DiffEntry struct {
+	Path    string `json:"path"`
+	Change  string `json:"change"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+	Delta   int64  `json:"delta"`
+	// Fields are the metadata that changed: mode and mtime.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// SnapshotDiff is what changed from one file list to another.
+type SnapshotDiff struct {
+	From     string      `json:"from"`
+	To       string      `json:"to"`
+	Entries  []DiffEntry `json:"entries"`
+	Added    int         `json:"added"`
+	Removed  int         `json:"removed"`
+	Modified int         `json:"modified"`
+	Metadata int         `json:"metadata"`
+	Delta    int64       `json:"delta"`
+}
+
+// DiffFiles compares two manifests by path, the entries are sorted by path
+// and unchanged files are left out.
+func DiffFiles(from []db.FileRecord, to []db.FileRecord) SnapshotDiff {
+	diff := SnapshotDiff{Entries: []DiffEntry{}}
+	old := make(map[string]db.FileRecord, len(from))
+	for _, file := range from {
+		old[file.Path] = file
+	}
+
+	add := func(entry DiffEntry) {
+		entry.Delta = entry.NewSize - entry.OldSize
+		diff.Delta += entry.Delta
+		diff.Entries = append(diff.Entries, entry)
+	}
+	for _, file := range to {
+		before, ok := old[file.Path]
+		if !ok {
+			diff.Added++
+			add(DiffEntry{Path: file.Path, Change: DiffAdded, NewSize: file.Size})
+			continue
+		}
+		delete(old, file.Path)
+		if before.MD5 != file.MD5 || entryChanged(before, file) {
+			diff.Modified++
+			add(DiffEntry{Path: file.Path, Change: DiffModified, OldSize: before.Size, NewSize: file.Size})
+			continue
+		}
+		var fields []string
+		if before.Permission != file.Permission {
+			fields = append(fields, "mode")
+		}
+		// older snapshots did not record the mtime
+		if !before.Modified.IsZero() && !file.Modified.IsZero() && !before.Modified.Equal(file.Modified) {
+			fields = append(fields, "mtime")
+		}
+		if fields != nil {
+			diff.Metadata++
+			add(DiffEntry{Path: file.Path, Change: DiffMetadata, OldSize: before.Size, NewSize: file.Size, Fields: fields})
+		}
+	}
+	for _, file := range old {
+		diff.Removed++
+		add(DiffEntry{Path: file.Path, Change: DiffRemoved, OldSize: file.Size})
+	}
+
+	sort.Slice(diff.Entries, func(i, j int) bool { return diff.Entries[i].Path < diff.Entries[j].Path })
+	return diff
+}
+
+var diffMarks = map[string]string{DiffAdded: "+", DiffRemoved: "-", DiffModified: "M", DiffMetadata: "m"}
+
+// Print writes a line per changed path and a summary.
+func (d SnapshotDiff) Print(w io.Writer) {
+	for _, entry := range d.Entries {
+		size := entry.NewSize
+		if entry.Change == DiffRemoved {
+			size = entry.OldSize
+		}
+		detail := ""
+		if entry.Fields != nil {
+			detail = "  (" + strings.Join(entry.Fields, ", ") + ")"
+		}
+		fmt.Fprintf(w, "%s %10s %11s  %s%s\n", diffMarks[entry.Change], FormatBytes(size), formatDelta(entry.Delta), entry.Path, detail)
+	}
+	fmt.Fprintf(w, "%s -> %s: %d added, %d removed, %d modified, %d metadata only, %s\n",
+		d.From, d.To, d.Added, d.Removed, d.Modified, d.Metadata, formatDelta(d.Delta))
+}
+
+// formatDelta is FormatBytes with the sign of growth.
+func formatDelta(delta int64) string {
+	if delta > 0 {
+		return "+" + FormatBytes(delta)
+	}
+	return FormatBytes(delta)
+}
+
+// FindSnapshot returns the snapshot a reference names: its id, a date as
+// restore --snap takes it, or "latest".
+func FindSnapshot(database *sql.DB, ref string) (*db.SnapShotRecord, error) {
+	var snap *db.SnapShotRecord
+	var err error
+	if id, atoi := strconv.Atoi(ref); atoi == nil {
+		snap, err = db.GetSnapshot(database, id)
+	} else if ref == "" || ref == "latest" {
+		snap, err = db.GetLastSnap(database)
+	} else {
+		snap, err = db.GetSnapByDate(database, ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting snapshot %s: %w", ref, err)
+	}
+	if snap == nil {
+		return nil, fmt.Errorf("no snapshot found for %q", ref)
+	}
+	return snap, nil
+}
+
+func snapshotLabel(snap *db.SnapShotRecord) string {
+	return fmt.Sprintf("snapshot %d (%s)", snap.Id, snap.Date)
+}
+
+// Diff prints what changed between two snapshots of the destination.
+func Diff(destination sources.Source, from string, to string, asJSON bool, setting sources.Setting) (err error) {
+	repo, err := OpenRepositoryReadOnly(destination, setting)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %w", err)
+	}
+	defer closeRepository(repo, &err)
+
+	var labels []string
+	var manifests [][]db.FileRecord
+	for _, ref := range []string{from, to} {
+		snap, err := FindSnapshot(repo.Database, ref)
+		if err != nil {
+			return err
+		}
+		files, err := db.ListFilesbySnapshot(repo.Database, snap.Id)
+		if err != nil {
+			return fmt.Errorf("error listing files of snapshot %d: %w", snap.Id, err)
+		}
+		labels = append(labels, snapshotLabel(snap))
+		manifests = append(manifests, files)
+	}
+
+	diff := DiffFiles(manifests[0], manifests[1])
+	diff.From, diff.To = labels[0], labels[1]
+	return writeDiff(os.Stdout, diff, asJSON)
+}
+
+// DiffLive prints what changed on the origin since a snapshot was taken.
+func DiffLive(origin sources.Source, destination sources.Source, ref string, asJSON bool, setting sources.Setting) (err error) {
+	repo, err := OpenRepositoryReadOnly(destination, setting)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %w", err)
+	}
+	defer closeRepository(repo, &err)
+
+	snap, err := FindSnapshot(repo.Database, ref)
+	if err != nil {
+		return err
+	}
+	files, err := db.ListFilesbySnapshot(repo.Database, snap.Id)
+	if err != nil {
+		return fmt.Errorf("error listing files of snapshot %d: %w", snap.Id, err)
+	}
+
+	var live []db.FileRecord
+	for file := range listEntries(origin) {
+		live = append(live, db.FileRecord{Path: file.Path, MD5: file.Md5, Permission: file.Permission, Size: file.Size, Modified: file.LastModified,
+			Type: file.Type, LinkTarget: file.LinkTarget, Device: file.Device})
+	}
+
+	diff := DiffFiles(files, live)
+	diff.From, diff.To = snapshotLabel(snap), "origin"
+	return writeDiff(os.Stdout, diff, asJSON)
+}
+
+// entryChanged reports whether an entry without content changed type or
+// points elsewhere. A file and its hard links share their content.
+func entryChanged(before db.FileRecord, after db.FileRecord) bool {
+	if sources.HasContent(before.Type) && sources.HasContent(after.Type) {
+		return false
+	}
+	return before.Type != after.Type || before.LinkTarget != after.LinkTarget || before.Device != after.Device
+}
+
+func writeDiff(w io.Writer, diff SnapshotDiff, asJSON bool) error {
+	if !asJSON {
+		diff.Print(w)
+		return nil
+	}
+	return writeJSON(w, diff)
+}
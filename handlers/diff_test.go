@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+	"uelei/capivara-sync/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffFiles(t *testing.T) {
+	before := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	after := before.Add(time.Hour)
+	from := []db.FileRecord{
+		{Path: "same.txt", MD5: "s", Permission: "-rw-r--r--", Size: 10, Modified: before},
+		{Path: "edited.txt", MD5: "e1", Permission: "-rw-r--r--", Size: 100, Modified: before},
+		{Path: "chmod.sh", MD5: "c", Permission: "-rw-r--r--", Size: 5, Modified: before},
+		{Path: "touched.txt", MD5: "t", Permission: "-rw-r--r--", Size: 7, Modified: before},
+		{Path: "legacy.txt", MD5: "l", Permission: "-rw-r--r--", Size: 3},
+		{Path: "gone.txt", MD5: "g", Permission: "-rw-r--r--", Size: 40, Modified: before},
+	}
+	to := []db.FileRecord{
+		{Path: "same.txt", MD5: "s", Permission: "-rw-r--r--", Size: 10, Modified: before},
+		{Path: "edited.txt", MD5: "e2", Permission: "-rw-r--r--", Size: 160, Modified: after},
+		{Path: "chmod.sh", MD5: "c", Permission: "-rwxr-xr-x", Size: 5, Modified: after},
+		{Path: "touched.txt", MD5: "t", Permission: "-rw-r--r--", Size: 7, Modified: after},
+		{Path: "legacy.txt", MD5: "l", Permission: "-rw-r--r--", Size: 3, Modified: after},
+		{Path: "new.txt", MD5: "n", Permission: "-rw-r--r--", Size: 25, Modified: after},
+	}
+
+	diff := DiffFiles(from, to)
+	assert.Equal(t, []DiffEntry{
+		{Path: "chmod.sh", Change: DiffMetadata, OldSize: 5, NewSize: 5, Fields: []string{"mode", "mtime"}},
+		{Path: "edited.txt", Change: DiffModified, OldSize: 100, NewSize: 160, Delta: 60},
+		{Path: "gone.txt", Change: DiffRemoved, OldSize: 40, Delta: -40},
+		{Path: "new.txt", Change: DiffAdded, NewSize: 25, Delta: 25},
+		{Path: "touched.txt", Change: DiffMetadata, OldSize: 7, NewSize: 7, Fields: []string{"mtime"}},
+	}, diff.Entries)
+	assert.Equal(t, 1, diff.Added)
+	assert.Equal(t, 1, diff.Removed)
+	assert.Equal(t, 1, diff.Modified)
+	assert.Equal(t, 2, diff.Metadata)
+	assert.Equal(t, int64(45), diff.Delta)
+
+	var out bytes.Buffer
+	diff.From, diff.To = "snapshot 1", "snapshot 2"
+	diff.Print(&out)
+	assert.Contains(t, out.String(), "-       40 B       -40 B  gone.txt\n")
+	assert.Contains(t, out.String(), "m        5 B         0 B  chmod.sh  (mode, mtime)\n")
+	assert.Contains(t, out.String(), "snapshot 1 -> snapshot 2: 1 added, 1 removed, 1 modified, 2 metadata only, +45 B\n")
+
+	out.Reset()
+	require.NoError(t, writeDiff(&out, diff, true))
+	var decoded SnapshotDiff
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, diff, decoded)
+
+	assert.Empty(t, DiffFiles(to, to).Entries)
+}
+
+func TestFindSnapshot(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "snapshot_files.db"))
+	require.NoError(t, err)
+	defer database.Close()
+
+	_, err = database.Exec(`INSERT INTO snapshots (date, status) VALUES
+		('2025-05-01 10:00:00', 'complete'), ('2025-05-02 10:00:00', 'complete'), ('2025-05-03 10:00:00', 'failed')`)
+	require.NoError(t, err)
+
+	for ref, id := range map[string]int{"1": 1, "3": 3, "latest": 2, "2025-05-01": 1, "2025-05-04": 2} {
+		snap, err := FindSnapshot(database, ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, id, snap.Id, ref)
+	}
+	_, err = FindSnapshot(database, "4")
+	assert.Error(t, err)
+	_, err = FindSnapshot(database, "2025-04-30")
+	assert.Error(t, err)
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RetentionPolicy tells which snapshots forget keeps: the Last most recent
+// ones and the most recent one of each of the last Daily days, Weekly weeks,
+// Monthly months and Yearly years that have snapshots.
+type RetentionPolicy struct {
+	Last    int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+func (p RetentionPolicy) Empty() bool {
+	return p.Last <= 0 && p.Daily <= 0 && p.Weekly <= 0 && p.Monthly <= 0 && p.Yearly <= 0
+}
+
+const snapshotDateLayout = "2006-01-02 15:04:05"
+
+// ApplyPolicy splits the snapshots in the ones the policy keeps and the ones
+// it removes, both newest first. Only complete snapshots fill the policy,
+// the incomplete ones newer than the last complete snapshot are kept to be
+// resumed and the older ones removed.
+func ApplyPolicy(snaps []db.SnapShotRecord, policy RetentionPolicy) (keep, remove []db.SnapShotRecord) {
+	sorted := append([]db.SnapShotRecord{}, snaps...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Date != sorted[j].Date {
+			return sorted[i].Date > sorted[j].Date
+		}
+		return sorted[i].Id > sorted[j].Id
+	})
+
+	rules := []struct {
+		count  int
+		bucket func(db.SnapShotRecord, time.Time) string
+	}{
+		{policy.Last, func(s db.SnapShotRecord, _ time.Time) string { return strconv.Itoa(s.Id) }},
+		{policy.Daily, func(_ db.SnapShotRecord, t time.Time) string { return t.Format("2006-01-02") }},
+		{policy.Weekly, func(_ db.SnapShotRecord, t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{policy.Monthly, func(_ db.SnapShotRecord, t time.Time) string { return t.Format("2006-01") }},
+		{policy.Yearly, func(_ db.SnapShotRecord, t time.Time) string { return t.Format("2006") }},
+	}
+	remaining := make([]int, len(rules))
+	last := make([]string, len(rules))
+	for i, rule := range rules {
+		remaining[i] = rule.count
+	}
+
+	complete := false
+	for _, snap := range sorted {
+		if !snap.Complete() {
+			if complete {
+				remove = append(remove, snap)
+			} else {
+				keep = append(keep, snap)
+			}
+			continue
+		}
+		complete = true
+		date, err := time.Parse(snapshotDateLayout, snap.Date)
+		if err != nil {
+			log.Warn("Keeping snapshot ", snap.Id, " with unknown date format: ", snap.Date)
+			keep = append(keep, snap)
+			continue
+		}
+
+		kept := false
+		for i, rule := range rules {
+			if remaining[i] <= 0 {
+				continue
+			}
+			if bucket := rule.bucket(snap, date); bucket != last[i] {
+				last[i] = bucket
+				remaining[i]--
+				kept = true
+			}
+		}
+		if kept {
+			keep = append(keep, snap)
+		} else {
+			remove = append(remove, snap)
+		}
+	}
+	return keep, remove
+}
+
+// Forget removes the snapshots the policy does not keep, and the blocks no
+// remaining snapshot uses when prune is set. Nothing is changed on dryRun.
+func Forget(destination sources.Source, policy RetentionPolicy, prune bool, dryRun bool, setting sources.Setting) (err error) {
+	if policy.Empty() {
+		return errors.New("no retention policy given, refusing to forget every snapshot")
+	}
+
+	repo, err := openForPrune(destination, setting, dryRun)
+	if err != nil {
+		return err
+	}
+	defer closeRepository(repo, &err)
+
+	snaps, err := db.ListSnapShots(repo.Database)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots: %w", err)
+	}
+	keep, remove := ApplyPolicy(snaps, policy)
+
+	for _, snp := range keep {
+		fmt.Println("keep   Snapshot ID:", snp.Id, "Date:", snp.Date)
+	}
+	for _, snp := range remove {
+		fmt.Println("remove Snapshot ID:", snp.Id, "Date:", snp.Date)
+		// the local copy of the database is discarded on dry run, removing
+		// the snapshots there lets prune report what it would reclaim
+		if err := db.DeleteSnapshot(repo.Database, snp.Id); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d snapshots kept, %d removed\n", len(keep), len(remove))
+
+	if prune {
+		return repo.prune(dryRun)
+	}
+	return nil
+}
+
+// Prune removes the blocks no snapshot uses anymore. Nothing is changed on dryRun.
+func Prune(destination sources.Source, dryRun bool, setting sources.Setting) (err error) {
+	repo, err := openForPrune(destination, setting, dryRun)
+	if err != nil {
+		return err
+	}
+	defer closeRepository(repo, &err)
+
+	return repo.prune(dryRun)
+}
+
+func openForPrune(destination sources.Source, setting sources.Setting, dryRun bool) (*Repository, error) {
+	if dryRun {
+		log.Warn("Dry run, the repository will not be changed")
+		return OpenRepositoryReadOnly(destination, setting)
+	}
+	return OpenRepository(destination, setting)
+}
+
+func (r *Repository) prune(dryRun bool) error {
+	blocks, err := db.UnreferencedBlocks(r.Database)
+	if err != nil {
+		return fmt.Errorf("error listing unreferenced blocks: %w", err)
+	}
+
+	var reclaimed int64
+	for _, block := range blocks {
+		reclaimed += block.StoredSize
+	}
+	if dryRun {
+		fmt.Printf("would remove %d blocks, reclaiming %s\n", len(blocks), FormatBytes(reclaimed))
+		return nil
+	}
+
+	if err := r.checkReaders(); err != nil {
+		return err
+	}
+	// the database stops referencing the blocks before they are removed, an
+	// interrupted prune leaves orphaned blocks and never a snapshot missing data
+	if err := db.PruneBlocks(r.Database, blocks); err != nil {
+		return err
+	}
+	if err := r.uploadDatabase(); err != nil {
+		return fmt.Errorf("error saving database file to remote storage: %w", err)
+	}
+	// a reader locking after this check downloads the database just saved,
+	// one that locked before may still restore from the blocks
+	if err := r.checkReaders(); err != nil {
+		return fmt.Errorf("%w, the blocks stay on the destination as orphans", err)
+	}
+
+	removed := 0
+	for _, block := range blocks {
+		remote_filename := r.GetRemoteFileName(block.Hash, block.Codec)
+		log.Debug("Removing block: ", remote_filename)
+		if err := r.Destination.RemoveFile(remote_filename); err != nil {
+			log.Error("Error removing block ", remote_filename, ": ", err)
+			continue
+		}
+		removed++
+	}
+	fmt.Printf("removed %d blocks, reclaimed %s\n", removed, FormatBytes(reclaimed))
+	return nil
+}
+
+// checkReaders fails while a restore or a check holds a reader lock.
+func (r *Repository) checkReaders() error {
+	readers, err := activeReaders(r.Destination)
+	if err != nil {
+		return fmt.Errorf("error listing reader locks: %w", err)
+	}
+	if len(readers) > 0 {
+		return fmt.Errorf("repository is being read by %s, not removing blocks", readers[0])
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ids(snaps []db.SnapShotRecord) []int {
+	var result []int
+	for _, s := range snaps {
+		result = append(result, s.Id)
+	}
+	return result
+}
+
+func TestApplyPolicy(t *testing.T) {
+	snaps := []db.SnapShotRecord{
+		{Id: 1, Date: "2024-12-31 10:00:00", Status: db.SnapshotComplete},
+		{Id: 2, Date: "2025-04-30 10:00:00", Status: db.SnapshotComplete},
+		{Id: 3, Date: "2025-05-01 08:00:00", Status: db.SnapshotComplete},
+		{Id: 4, Date: "2025-05-01 20:00:00", Status: db.SnapshotComplete},
+		{Id: 5, Date: "2025-05-02 08:00:00", Status: db.SnapshotComplete},
+		{Id: 6, Date: "2025-05-03 08:00:00", Status: db.SnapshotComplete},
+	}
+
+	keep, remove := ApplyPolicy(snaps, RetentionPolicy{Last: 2})
+	assert.Equal(t, []int{6, 5}, ids(keep))
+	assert.Equal(t, []int{4, 3, 2, 1}, ids(remove))
+
+	keep, _ = ApplyPolicy(snaps, RetentionPolicy{Daily: 3})
+	assert.Equal(t, []int{6, 5, 4}, ids(keep))
+
+	keep, _ = ApplyPolicy(snaps, RetentionPolicy{Monthly: 2, Yearly: 2})
+	assert.Equal(t, []int{6, 2, 1}, ids(keep))
+
+	keep, remove = ApplyPolicy(snaps, RetentionPolicy{Last: 1, Daily: 10})
+	assert.Equal(t, []int{6, 5, 4, 2, 1}, ids(keep))
+	assert.Equal(t, []int{3}, ids(remove))
+}
+
+func TestApplyPolicyIncomplete(t *testing.T) {
+	snaps := []db.SnapShotRecord{
+		{Id: 1, Date: "2025-05-01 08:00:00", Status: db.SnapshotComplete},
+		{Id: 2, Date: "2025-05-02 08:00:00", Status: db.SnapshotFailed},
+		{Id: 3, Date: "2025-05-03 08:00:00", Status: db.SnapshotComplete},
+		{Id: 4, Date: "2025-05-04 08:00:00", Status: db.SnapshotFailed},
+	}
+
+	// the failed snapshot is kept to be resumed, not in place of snapshot 3
+	keep, remove := ApplyPolicy(snaps, RetentionPolicy{Last: 1})
+	assert.Equal(t, []int{4, 3}, ids(keep))
+	assert.Equal(t, []int{2, 1}, ids(remove))
+
+	keep, remove = ApplyPolicy(snaps[1:2], RetentionPolicy{Last: 1})
+	assert.Equal(t, []int{2}, ids(keep))
+	assert.Empty(t, remove)
+}
+
+func TestPruneWaitsForReaders(t *testing.T) {
+	destination, blocks := checkedRepository(t, sources.Setting{})
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "c.txt"), []byte("gamma"), 0644))
+	require.NoError(t, Backup(sources.NewLocalsource(dir), destination, sources.Setting{Jobs: 1}))
+
+	reader, err := OpenRepositoryShared(destination, sources.Setting{})
+	require.NoError(t, err)
+	err = Forget(destination, RetentionPolicy{Last: 1}, true, false, sources.Setting{})
+	assert.ErrorContains(t, err, "repository is being read by")
+	for _, block := range blocks {
+		assert.FileExists(t, block)
+	}
+
+	reader.Close()
+	require.NoError(t, Prune(destination, false, sources.Setting{}))
+	for _, block := range blocks {
+		assert.NoFileExists(t, block)
+	}
+	remaining, err := filepath.Glob(filepath.Join(destination.Localpath, "lock-*"))
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const LockFileName = "lock.json"
+
+// Commands reading blocks each keep a lock-<nonce>.json reader lock on the
+// destination, prune does not remove blocks while one is held.
+const (
+	readerLockPrefix = "lock-"
+	readerLockSuffix = ".json"
+)
+
+// A lock is refreshed while its owner runs, one not refreshed for
+// StaleLockAge belongs to a process that died on another host.
+var (
+	LockRefreshInterval = 5 * time.Minute
+	StaleLockAge        = 30 * time.Minute
+	lockSettleDelay     = 500 * time.Millisecond
+)
+
+// Lock is the content of the lock file kept on the destination while a
+// command writes to the repository.
+type Lock struct {
+	Hostname  string    `json:"hostname"`
+	PID       int       `json:"pid"`
+	Created   time.Time `json:"created"`
+	Refreshed time.Time `json:"refreshed"`
+	Nonce     string    `json:"nonce"`
+}
+
+func (l Lock) String() string {
+	return fmt.Sprintf("%s (PID %d) since %s", l.Hostname, l.PID, TimeToString(l.Created))
+}
+
+// Stale reports whether the lock owner is gone: a process of this host that
+// no longer runs, or a lock that was not refreshed for StaleLockAge.
+func (l Lock) Stale() bool {
+	if hostname, _ := os.Hostname(); hostname == l.Hostname {
+		return !processExists(l.PID)
+	}
+	return time.Since(l.Refreshed) > StaleLockAge
+}
+
+func ReadLock(destination sources.Source) (*Lock, error) {
+	return readLock(destination, LockFileName)
+}
+
+func readLock(destination sources.Source, name string) (*Lock, error) {
+	data, err := destination.GetFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var lock Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file: %w", err)
+	}
+	return &lock, nil
+}
+
+func writeLock(destination sources.Source, name string, lock *Lock) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return destination.SaveFile(name, data, "-rw-r--r--")
+}
+
+func newLock() (*Lock, error) {
+	hostname, _ := os.Hostname()
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Lock{Hostname: hostname, PID: os.Getpid(), Created: now, Refreshed: now, Nonce: hex.EncodeToString(nonce)}, nil
+}
+
+// AcquireLock takes the repository lock, replacing a stale one.
+func AcquireLock(destination sources.Source) (*Lock, error) {
+	if destination.Exists(LockFileName) {
+		existing, err := ReadLock(destination)
+		if err != nil {
+			return nil, fmt.Errorf("repository is locked: %w", err)
+		}
+		if !existing.Stale() {
+			return nil, fmt.Errorf("repository is locked by %s", existing)
+		}
+		log.Warn("Replacing stale lock held by ", existing)
+	}
+
+	lock, err := newLock()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeLock(destination, LockFileName, lock); err != nil {
+		return nil, fmt.Errorf("error writing lock file: %w", err)
+	}
+
+	// another process may have taken the lock at the same time, the last
+	// write wins and the other one backs off
+	time.Sleep(lockSettleDelay)
+	current, err := ReadLock(destination)
+	if err != nil {
+		return nil, fmt.Errorf("error reading lock file: %w", err)
+	}
+	if current.Nonce != lock.Nonce {
+		return nil, fmt.Errorf("repository is locked by %s", current)
+	}
+	return lock, nil
+}
+
+// readerLockName is the file of a reader lock.
+func readerLockName(lock *Lock) string {
+	return readerLockPrefix + lock.Nonce + readerLockSuffix
+}
+
+// AcquireReaderLock takes a reader lock, any number of them can be held
+// with or without the repository lock.
+func AcquireReaderLock(destination sources.Source) (*Lock, error) {
+	lock, err := newLock()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeLock(destination, readerLockName(lock), lock); err != nil {
+		return nil, fmt.Errorf("error writing reader lock file: %w", err)
+	}
+	return lock, nil
+}
+
+// readerLocks returns the reader locks by file name, nil for the ones that
+// cannot be read.
+func readerLocks(destination sources.Source) (map[string]*Lock, error) {
+	names, err := listNames(destination)
+	if err != nil {
+		return nil, err
+	}
+	readers := map[string]*Lock{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, readerLockPrefix) || !strings.HasSuffix(name, readerLockSuffix) {
+			continue
+		}
+		lock, err := readLock(destination, name)
+		if err != nil {
+			// the reader may have released it since the listing
+			log.Debug("Error reading reader lock ", name, ": ", err)
+		}
+		readers[name] = lock
+	}
+	return readers, nil
+}
+
+// activeReaders returns the reader locks whose owner still runs.
+func activeReaders(destination sources.Source) ([]*Lock, error) {
+	locks, err := readerLocks(destination)
+	if err != nil {
+		return nil, err
+	}
+	var readers []*Lock
+	for _, lock := range locks {
+		if lock == nil {
+			continue
+		}
+		if lock.Stale() {
+			log.Warn("Ignoring stale reader lock held by ", lock)
+			continue
+		}
+		readers = append(readers, lock)
+	}
+	return readers, nil
+}
+
+// refreshLock keeps the lock file name fresh until stop is closed, then
+// removes it and sends the error removing it to done.
+func refreshLock(destination sources.Source, name string, lock *Lock, stop <-chan struct{}, done chan<- error) {
+	ticker := time.NewTicker(LockRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			lock.Refreshed = time.Now()
+			if err := writeLock(destination, name, lock); err != nil {
+				log.Error("Error refreshing lock:", err)
+			}
+		case <-stop:
+			done <- destination.RemoveFile(name)
+			return
+		}
+	}
+}
+
+// Unlock removes the repository lock and the reader locks when their owner
+// is gone, or unconditionally with force.
+func Unlock(destination sources.Source, force bool) error {
+	if err := unlockReaders(destination, force); err != nil {
+		return err
+	}
+	if !destination.Exists(LockFileName) {
+		fmt.Println("Repository is not locked")
+		return nil
+	}
+	lock, err := ReadLock(destination)
+	if err != nil && !force {
+		return err
+	}
+	if err == nil && !lock.Stale() && !force {
+		return errors.New("repository is locked by " + lock.String() + " which is still running, use --force to remove it anyway")
+	}
+
+	if err := destination.RemoveFile(LockFileName); err != nil {
+		return fmt.Errorf("error removing lock: %w", err)
+	}
+	if lock != nil {
+		fmt.Println("Removed lock held by", lock)
+	} else {
+		fmt.Println("Removed lock")
+	}
+	return nil
+}
+
+// unlockReaders removes the reader locks whose owner is gone, or every one
+// with force.
+func unlockReaders(destination sources.Source, force bool) error {
+	readers, err := readerLocks(destination)
+	if err != nil {
+		return fmt.Errorf("error listing reader locks: %w", err)
+	}
+	for name, lock := range readers {
+		if !force && lock == nil {
+			continue
+		}
+		if !force && !lock.Stale() {
+			fmt.Println("Keeping reader lock held by", lock, "which is still running, use --force to remove it anyway")
+			continue
+		}
+		if err := destination.RemoveFile(name); err != nil {
+			return fmt.Errorf("error removing reader lock: %w", err)
+		}
+		if lock != nil {
+			fmt.Println("Removed reader lock held by", lock)
+		} else {
+			fmt.Println("Removed reader lock", name)
+		}
+	}
+	return nil
+}
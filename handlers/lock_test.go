@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"os"
+	"testing"
+	"time"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireLock(t *testing.T) {
+	lockSettleDelay = 0
+	destination := sources.Localsource{Localpath: t.TempDir() + "/"}
+
+	lock, err := AcquireLock(destination)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), lock.PID)
+
+	_, err = AcquireLock(destination)
+	assert.ErrorContains(t, err, "repository is locked")
+
+	assert.Error(t, Unlock(destination, false))
+	require.NoError(t, Unlock(destination, true))
+	assert.False(t, destination.Exists(LockFileName))
+}
+
+func TestStaleLock(t *testing.T) {
+	lockSettleDelay = 0
+	destination := sources.Localsource{Localpath: t.TempDir() + "/"}
+
+	old := time.Now().Add(-2 * StaleLockAge)
+	require.NoError(t, writeLock(destination, LockFileName, &Lock{Hostname: "other-host", PID: 1, Created: old, Refreshed: old}))
+	existing, err := ReadLock(destination)
+	require.NoError(t, err)
+	assert.True(t, existing.Stale())
+
+	lock, err := AcquireLock(destination)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), lock.PID)
+
+	lock.Hostname = "other-host"
+	lock.Refreshed = time.Now()
+	assert.False(t, lock.Stale())
+}
+
+func TestReaderLock(t *testing.T) {
+	destination := sources.Localsource{Localpath: t.TempDir() + "/"}
+
+	lock, err := AcquireReaderLock(destination)
+	require.NoError(t, err)
+	_, err = AcquireReaderLock(destination)
+	require.NoError(t, err)
+	old := time.Now().Add(-2 * StaleLockAge)
+	require.NoError(t, writeLock(destination, "lock-stale.json", &Lock{Hostname: "other-host", PID: 1, Created: old, Refreshed: old}))
+
+	readers, err := activeReaders(destination)
+	require.NoError(t, err)
+	assert.Len(t, readers, 2)
+
+	require.NoError(t, destination.RemoveFile(readerLockName(lock)))
+	readers, err = activeReaders(destination)
+	require.NoError(t, err)
+	assert.Len(t, readers, 1)
+}
+
+func TestUnlockReaders(t *testing.T) {
+	lockSettleDelay = 0
+	destination := sources.Localsource{Localpath: t.TempDir() + "/"}
+
+	lock, err := AcquireReaderLock(destination)
+	require.NoError(t, err)
+	old := time.Now().Add(-2 * StaleLockAge)
+	require.NoError(t, writeLock(destination, "lock-stale.json", &Lock{Hostname: "other-host", PID: 1, Created: old, Refreshed: old}))
+
+	require.NoError(t, Unlock(destination, false))
+	assert.False(t, destination.Exists("lock-stale.json"))
+	assert.True(t, destination.Exists(readerLockName(lock)))
+
+	require.NoError(t, Unlock(destination, true))
+	assert.False(t, destination.Exists(readerLockName(lock)))
+}
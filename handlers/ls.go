@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+)
+
+// MatchPath reports whether a glob pattern matches a snapshot path, a
+// pattern without a slash matches the file name in any directory.
+func MatchPath(pattern string, name string) bool {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	pattern = strings.Trim(pattern, "/")
+	if matched, _ := path.Match(pattern, name); matched {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(name))
+		return matched
+	}
+	return false
+}
+
+// inDir reports whether a snapshot path is dir or under it, "" is the root.
+func inDir(dir string, name string) bool {
+	dir = strings.Trim(path.Clean("/"+dir), "/")
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return dir == "" || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// SelectFiles returns the files of a manifest under dir matching one of the
+// patterns, every file under dir without patterns.
+func SelectFiles(files []db.FileRecord, dir string, patterns []string) []db.FileRecord {
+	var selected []db.FileRecord
+	for _, file := range files {
+		if !inDir(dir, file.Path) {
+			continue
+		}
+		keep := len(patterns) == 0
+		for _, pattern := range patterns {
+			keep = keep || MatchPath(pattern, file.Path)
+		}
+		if keep {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
+
+// treeNode is a directory of the tree ls prints, or a file when file is set.
+type treeNode struct {
+	name     string
+	file     *db.FileRecord
+	children map[string]*treeNode
+	size     int64
+	files    int
+	modified time.Time
+}
+
+func buildTree(files []db.FileRecord) *treeNode {
+	root := &treeNode{children: map[string]*treeNode{}}
+	for i := range files {
+		file := &files[i]
+		parts := strings.Split(strings.TrimPrefix(path.Clean("/"+file.Path), "/"), "/")
+		// a directory entry only makes sure an empty directory is shown
+		dir := file.Type == sources.EntryDir
+		node := root
+		for depth, part := range parts {
+			if dir {
+				if child, ok := node.children[part]; ok {
+					node = child
+					continue
+				}
+			} else {
+				node.size += file.Size
+				node.files++
+				if file.Modified.After(node.modified) {
+					node.modified = file.Modified
+				}
+			}
+			child, ok := node.children[part]
+			if !ok {
+				child = &treeNode{name: part, children: map[string]*treeNode{}}
+				node.children[part] = child
+			}
+			if depth == len(parts)-1 && !dir {
+				child.file = file
+				child.size = file.Size
+				child.modified = file.Modified
+			}
+			node = child
+		}
+	}
+	return root
+}
+
+func (n *treeNode) sorted() []*treeNode {
+	children := make([]*treeNode, 0, len(n.children))
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+// PrintTree writes the files as a directory tree, long adds the mode and
+// mtime of every entry. Directories show the size of what they hold.
+func PrintTree(w io.Writer, files []db.FileRecord, long bool) {
+	root := buildTree(files)
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var walk func(node *treeNode, indent string)
+	walk = func(node *treeNode, indent string) {
+		children := node.sorted()
+		for i, child := range children {
+			branch, next := "├── ", "│   "
+			if i == len(children)-1 {
+				branch, next = "└── ", "    "
+			}
+			name, mode := child.name, "d"
+			if child.file == nil {
+				name += "/"
+			} else {
+				mode = orDash(child.file.Permission)
+			}
+			if child.file != nil && child.file.Type == sources.EntrySymlink {
+				name += " -> " + child.file.LinkTarget
+			}
+			if long {
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s%s%s\n", mode, FormatBytes(child.size), formatModified(child.modified), indent, branch, name)
+			} else {
+				fmt.Fprintf(table, "%s%s%s\t%s\n", indent, branch, name, FormatBytes(child.size))
+			}
+			if child.file == nil {
+				walk(child, indent+next)
+			}
+		}
+	}
+	walk(root, "")
+	table.Flush()
+	fmt.Fprintf(w, "%d files, %s\n", root.files, FormatBytes(root.size))
+}
+
+func formatModified(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return TimeToString(t)
+}
+
+// lsEntry is a file of ls --json.
+type lsEntry struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Mode     string    `json:"mode"`
+	Modified time.Time `json:"mtime"`
+	MD5      string    `json:"md5"`
+}
+
+// Ls prints the files of a snapshot under dir matching the patterns.
+func Ls(destination sources.Source, ref string, dir string, patterns []string, long bool, asJSON bool, setting sources.Setting) (err error) {
+	repo, err := OpenRepositoryReadOnly(destination, setting)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %w", err)
+	}
+	defer closeRepository(repo, &err)
+
+	snap, err := FindSnapshot(repo.Database, ref)
+	if err != nil {
+		return err
+	}
+	files, err := db.ListFilesbySnapshot(repo.Database, snap.Id)
+	if err != nil {
+		return fmt.Errorf("error listing files of snapshot %d: %w", snap.Id, err)
+	}
+	files = SelectFiles(files, dir, patterns)
+
+	if asJSON {
+		entries := make([]lsEntry, 0, len(files))
+		for _, file := range files {
+			entries = append(entries, lsEntry{Path: file.Path, Size: file.Size, Mode: file.Permission, Modified: file.Modified, MD5: file.MD5})
+		}
+		return writeJSON(os.Stdout, entries)
+	}
+	fmt.Printf("%s\n", snapshotLabel(snap))
+	PrintTree(os.Stdout, files, long)
+	return nil
+}
+
+// FoundVersion is a snapshot holding a path found by find.
+type FoundVersion struct {
+	Snapshot int       `json:"snapshot"`
+	Date     string    `json:"date"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"mtime"`
+	MD5      string    `json:"md5"`
+}
+
+type FoundPath struct {
+	Path      string         `json:"path"`
+	Snapshots []FoundVersion `json:"snapshots"`
+}
+
+// FindPaths groups the files of every snapshot matching a pattern by path,
+// the snapshots of a path in the order they were taken.
+func FindPaths(files []db.FileRecord, snaps []db.SnapShotRecord, pattern string) []FoundPath {
+	dates := make(map[int]string, len(snaps))
+	for _, snap := range snaps {
+		dates[snap.Id] = snap.Date
+	}
+	found := []FoundPath{}
+	for _, file := range files {
+		if !MatchPath(pattern, file.Path) {
+			continue
+		}
+		if len(found) == 0 || found[len(found)-1].Path != file.Path {
+			found = append(found, FoundPath{Path: file.Path})
+		}
+		last := &found[len(found)-1]
+		last.Snapshots = append(last.Snapshots, FoundVersion{Snapshot: file.SnapId, Date: dates[file.SnapId], Size: file.Size, Modified: file.Modified, MD5: file.MD5})
+	}
+	for _, f := range found {
+		sort.SliceStable(f.Snapshots, func(i, j int) bool { return f.Snapshots[i].Date < f.Snapshots[j].Date })
+	}
+	return found
+}
+
+// PrintFound writes a line per path with the snapshots holding it and how
+// many versions of its content they have.
+func PrintFound(w io.Writer, found []FoundPath) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PATH\tSIZE\tVERSIONS\tSNAPSHOTS")
+	for _, f := range found {
+		var ids []string
+		versions := map[string]bool{}
+		for _, version := range f.Snapshots {
+			ids = append(ids, fmt.Sprint(version.Snapshot))
+			versions[version.MD5] = true
+		}
+		latest := f.Snapshots[len(f.Snapshots)-1]
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\n", f.Path, FormatBytes(latest.Size), len(versions), strings.Join(ids, ","))
+	}
+	table.Flush()
+	fmt.Fprintf(w, "%d paths found\n", len(found))
+}
+
+// Find prints the paths matching a pattern in any snapshot of the
+// destination.
+func Find(destination sources.Source, pattern string, asJSON bool, setting sources.Setting) (err error) {
+	repo, err := OpenRepositoryReadOnly(destination, setting)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %w", err)
+	}
+	defer closeRepository(repo, &err)
+
+	snaps, err := db.ListSnapShots(repo.Database)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots: %w", err)
+	}
+	files, err := db.ListFiles(repo.Database)
+	if err != nil {
+		return fmt.Errorf("error listing files: %w", err)
+	}
+	found := FindPaths(files, snaps, pattern)
+	if asJSON {
+		return writeJSON(os.Stdout, found)
+	}
+	PrintFound(os.Stdout, found)
+	return nil
+}
+
+func writeJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
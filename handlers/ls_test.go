@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"testing"
+	"uelei/capivara-sync/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchPath(t *testing.T) {
+	assert.True(t, MatchPath("*.jpg", "a.jpg"))
+	assert.True(t, MatchPath("*.jpg", "photos/trip/a.jpg"))
+	assert.True(t, MatchPath("photos/*/a.jpg", "photos/trip/a.jpg"))
+	assert.True(t, MatchPath("/photos/trip/a.jpg", "photos/trip/a.jpg"))
+	assert.False(t, MatchPath("photos/*.jpg", "photos/trip/a.jpg"))
+	assert.False(t, MatchPath("*.png", "photos/a.jpg"))
+}
+
+func TestSelectFiles(t *testing.T) {
+	files := []db.FileRecord{{Path: "a.txt"}, {Path: "docs/b.txt"}, {Path: "docs/c.md"}, {Path: "docsold/d.txt"}}
+	paths := func(files []db.FileRecord) []string {
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		return paths
+	}
+	assert.Equal(t, []string{"a.txt", "docs/b.txt", "docs/c.md", "docsold/d.txt"}, paths(SelectFiles(files, "", nil)))
+	assert.Equal(t, []string{"docs/b.txt", "docs/c.md"}, paths(SelectFiles(files, "docs/", nil)))
+	assert.Equal(t, []string{"docs/b.txt"}, paths(SelectFiles(files, "docs", []string{"*.txt"})))
+	assert.Equal(t, []string{"a.txt", "docs/c.md"}, paths(SelectFiles(files, "", []string{"a.*", "*.md"})))
+}
+
+func TestPrintTree(t *testing.T) {
+	files := []db.FileRecord{
+		{Path: "b.txt", Size: 10, Permission: "-rw-r--r--"},
+		{Path: "docs/a.md", Size: 1024},
+		{Path: "docs/old/c.md", Size: 2048},
+	}
+	var out bytes.Buffer
+	PrintTree(&out, files, false)
+	assert.Equal(t, ""+
+		"├── b.txt         10 B\n"+
+		"└── docs/         3.0 KiB\n"+
+		"    ├── a.md      1.0 KiB\n"+
+		"    └── old/      2.0 KiB\n"+
+		"        └── c.md  2.0 KiB\n"+
+		"3 files, 3.0 KiB\n", out.String())
+
+	out.Reset()
+	PrintTree(&out, files[:1], true)
+	assert.Equal(t, "-rw-r--r--  10 B  -  └── b.txt\n1 files, 10 B\n", out.String())
+}
+
+func TestFindPaths(t *testing.T) {
+	snaps := []db.SnapShotRecord{{Id: 1, Date: "2025-05-01"}, {Id: 2, Date: "2025-05-02"}, {Id: 3, Date: "2025-05-03"}}
+	files := []db.FileRecord{
+		{Path: "a.jpg", MD5: "a1", SnapId: 1},
+		{Path: "a.jpg", MD5: "a1", SnapId: 2},
+		{Path: "a.jpg", MD5: "a2", SnapId: 3, Size: 20},
+		{Path: "b.txt", MD5: "b", SnapId: 1},
+		{Path: "trip/c.jpg", MD5: "c", SnapId: 3},
+	}
+	found := FindPaths(files, snaps, "*.jpg")
+	assert.Len(t, found, 2)
+	assert.Equal(t, "a.jpg", found[0].Path)
+	assert.Len(t, found[0].Snapshots, 3)
+	assert.Equal(t, "2025-05-03", found[0].Snapshots[2].Date)
+	assert.Equal(t, "trip/c.jpg", found[1].Path)
+
+	var out bytes.Buffer
+	PrintFound(&out, found)
+	assert.Contains(t, out.String(), "a.jpg       20 B  2         1,2,3\n")
+	assert.Contains(t, out.String(), "2 paths found\n")
+
+	assert.Empty(t, FindPaths(files, snaps, "*.png"))
+}
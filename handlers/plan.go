@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"uelei/capivara-sync/sources"
+)
+
+// Actions of a plan entry, in the order a plan is printed.
+const (
+	PlanAdd    = "add"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+	PlanSkip   = "skip"
+)
+
+var planOrder = map[string]int{PlanAdd: 0, PlanUpdate: 1, PlanDelete: 2, PlanSkip: 3}
+
+type PlanEntry struct {
+	Action string
+	Path   string
+	Reason string
+	Size   int64
+}
+
+// Plan collects what a dry run decided to do with every file. A nil plan
+// ignores the entries, so handlers record them whether or not they run dry.
+type Plan struct {
+	mu      sync.Mutex
+	Entries []PlanEntry
+}
+
+// newPlan returns a plan on dry runs and nil otherwise.
+func newPlan(setting sources.Setting) *Plan {
+	if !setting.DryRun {
+		return nil
+	}
+	return &Plan{}
+}
+
+func (p *Plan) Add(action string, path string, reason string, size int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Entries = append(p.Entries, PlanEntry{Action: action, Path: path, Reason: reason, Size: size})
+}
+
+// Print writes the entries grouped by action and a summary, the operations
+// are the changes the dry run source was asked to make.
+func (p *Plan) Print(w io.Writer, operations []sources.Operation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sort.SliceStable(p.Entries, func(i, j int) bool {
+		if p.Entries[i].Action != p.Entries[j].Action {
+			return planOrder[p.Entries[i].Action] < planOrder[p.Entries[j].Action]
+		}
+		return p.Entries[i].Path < p.Entries[j].Path
+	})
+
+	counts := map[string]int{}
+	sizes := map[string]int64{}
+	for _, entry := range p.Entries {
+		fmt.Fprintf(w, "%-6s %10s  %s  (%s)\n", entry.Action, FormatBytes(entry.Size), entry.Path, entry.Reason)
+		counts[entry.Action]++
+		sizes[entry.Action] += entry.Size
+	}
+	fmt.Fprintf(w, "plan: %d to add (%s), %d to update (%s), %d to delete (%s), %d skipped\n",
+		counts[PlanAdd], FormatBytes(sizes[PlanAdd]),
+		counts[PlanUpdate], FormatBytes(sizes[PlanUpdate]),
+		counts[PlanDelete], FormatBytes(sizes[PlanDelete]),
+		counts[PlanSkip])
+
+	var writes, removes int
+	var written int64
+	for _, op := range operations {
+		switch op.Kind {
+		case "write":
+			writes++
+			written += op.Size
+		case "remove":
+			removes++
+		}
+	}
+	fmt.Fprintf(w, "dry run: %d files not written (%s), %d not removed\n", writes, FormatBytes(written), removes)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanPrint(t *testing.T) {
+	var nilPlan *Plan
+	nilPlan.Add(PlanAdd, "ignored", "no dry run", 1)
+
+	plan := &Plan{}
+	plan.Add(PlanSkip, "b.txt", "already backed up", 10)
+	plan.Add(PlanDelete, "old.txt", "not in the origin", 5)
+	plan.Add(PlanAdd, "z.txt", "new", 2048)
+	plan.Add(PlanAdd, "a.txt", "new", 1)
+	plan.Add(PlanUpdate, "c.txt", "changed", 3)
+
+	var out strings.Builder
+	plan.Print(&out, []sources.Operation{{Kind: "write", Path: "block_1", Size: 100}, {Kind: "remove", Path: "old.txt"}})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	assert.Len(t, lines, 7)
+	for i, prefix := range []string{"add", "add", "update", "delete", "skip"} {
+		assert.True(t, strings.HasPrefix(lines[i], prefix), lines[i])
+	}
+	assert.Contains(t, lines[0], "a.txt")
+	assert.Contains(t, lines[1], "z.txt")
+	assert.Contains(t, lines[5], "2 to add (2.0 KiB), 1 to update (3 B), 1 to delete (5 B), 1 skipped")
+	assert.Contains(t, lines[6], "1 files not written (100 B), 1 not removed")
+}
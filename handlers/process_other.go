@@ -0,0 +1,14 @@
+//go:build !unix
+
+package handlers
+
+import "os"
+
+func processExists(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = process.Release()
+	return true
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"uelei/capivara-sync/compressor"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/encryptor"
+	"uelei/capivara-sync/sources"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DatabaseFileName = "snapshot_files.db"
+	KeyFileName      = "key.json"
+)
+
+// Repository is a destination holding the blocks and the snapshot database.
+type Repository struct {
+	Destination sources.Source
+	Database    *sql.DB
+	// Key encrypts everything written to the destination, nil when the
+	// repository is not encrypted.
+	Key *encryptor.Key
+	// codec compresses the blocks stored by this run.
+	codec compressor.Codec
+
+	// verified keeps why blocks checked during this run need an upload, ""
+	// for the ones the destination holds, and uploads the blocks uploaded.
+	verified sync.Map
+	uploads  sync.Map
+	// dbfile is the local working copy of the snapshot database.
+	dbfile   string
+	readonly bool
+	lock     *Lock
+	stop     chan struct{}
+	stopped  chan error
+}
+
+// OpenRepository locks the repository and loads its key and snapshot
+// database, an encrypted repository is created when setting.Encrypt is set.
+// Close saves the database back to the destination and releases the lock.
+func OpenRepository(destination sources.Source, setting sources.Setting) (*Repository, error) {
+	return openRepository(destination, setting, false, false)
+}
+
+// OpenRepositoryReadOnly loads the repository without locking it, the
+// database is never written back.
+func OpenRepositoryReadOnly(destination sources.Source, setting sources.Setting) (*Repository, error) {
+	return openRepository(destination, setting, true, false)
+}
+
+// OpenRepositoryShared loads the repository read only holding a reader
+// lock, prune leaves the blocks in place until Close releases it.
+func OpenRepositoryShared(destination sources.Source, setting sources.Setting) (*Repository, error) {
+	return openRepository(destination, setting, true, true)
+}
+
+func openRepository(destination sources.Source, setting sources.Setting, readonly bool, shared bool) (repo *Repository, err error) {
+	repo = &Repository{Destination: destination, readonly: readonly, codec: compressor.Uncompressed}
+	if setting.Compress {
+		if repo.codec, err = compressor.ParseCodec(setting.Codec); err != nil {
+			return nil, err
+		}
+	}
+
+	if !readonly || shared {
+		lock, name := (*Lock)(nil), LockFileName
+		if readonly {
+			lock, err = AcquireReaderLock(destination)
+		} else {
+			lock, err = AcquireLock(destination)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if readonly {
+			name = readerLockName(lock)
+		}
+		repo.lock = lock
+		repo.stop = make(chan struct{})
+		repo.stopped = make(chan error, 1)
+		go refreshLock(destination, name, lock, repo.stop, repo.stopped)
+	}
+	// the returns below set repo to nil
+	opened := repo
+	defer func() {
+		if err != nil {
+			if err := opened.release(); err != nil {
+				log.Error(err)
+			}
+		}
+	}()
+
+	key, err := loadKey(destination, setting)
+	if err != nil {
+		return nil, err
+	}
+	repo.Key = key
+
+	if err := repo.downloadDatabase(); err != nil {
+		return nil, err
+	}
+	database, err := db.InitDB(repo.dbfile)
+	if err != nil {
+		return nil, err
+	}
+	repo.Database = database
+	return repo, nil
+}
+
+func loadKey(destination sources.Source, setting sources.Setting) (*encryptor.Key, error) {
+	if destination.Exists(KeyFileName) {
+		keyfile, err := destination.GetFile(KeyFileName)
+		if err != nil {
+			return nil, fmt.Errorf("error getting key file: %w", err)
+		}
+		if setting.Password == "" {
+			return nil, errors.New("repository is encrypted, a password is required")
+		}
+		return encryptor.Unwrap(keyfile, setting.Password)
+	}
+	if !setting.Encrypt {
+		return nil, nil
+	}
+
+	if destination.Exists(DatabaseFileName) {
+		return nil, errors.New("destination already holds unencrypted backups, use a new destination to encrypt")
+	}
+	if setting.Password == "" {
+		return nil, errors.New("a password is required to encrypt the repository")
+	}
+	log.Info("Creating encrypted repository")
+	key, err := encryptor.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	keyfile, err := key.Wrap(setting.Password)
+	if err != nil {
+		return nil, err
+	}
+	if err := destination.SaveFile(KeyFileName, keyfile, "-rw-------"); err != nil {
+		return nil, fmt.Errorf("error saving key file: %w", err)
+	}
+	return key, nil
+}
+
+// Close saves the snapshot database back to the destination and releases
+// the repository lock.
+func (r *Repository) Close() error {
+	log.Info("Clean up environment")
+	var errs []error
+	if err := r.Database.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("error closing database: %w", err))
+	} else if !r.readonly {
+		log.Info("Saving database file to remote storage")
+		if err := r.uploadDatabase(); err != nil {
+			errs = append(errs, fmt.Errorf("error saving database file to remote storage: %w", err))
+		}
+	}
+	if err := r.release(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// closeRepository closes the repository of a command returning err, the
+// error of Close is returned unless the command already failed.
+func closeRepository(repo *Repository, err *error) {
+	if cerr := repo.Close(); cerr != nil {
+		if *err != nil {
+			log.Error(cerr)
+			return
+		}
+		*err = cerr
+	}
+}
+
+// Checkpoint saves the database to the destination while the repository
+// stays open, what was recorded so far survives an interrupted run.
+func (r *Repository) Checkpoint() error {
+	if r.readonly {
+		return nil
+	}
+	log.Info("Saving database checkpoint to remote storage")
+	return r.uploadDatabase()
+}
+
+// release removes the local database and the lock, it returns the error
+// removing the lock.
+func (r *Repository) release() error {
+	if r.dbfile != "" {
+		if err := os.Remove(r.dbfile); err != nil {
+			log.Error("Error removing local database file:", err)
+		}
+	}
+	if r.lock == nil {
+		return nil
+	}
+	close(r.stop)
+	err := <-r.stopped
+	r.lock = nil
+	if err != nil {
+		return fmt.Errorf("error removing lock: %w", err)
+	}
+	return nil
+}
+
+// downloadDatabase copies the snapshot database to a local working file, the
+// remote copy stays in place until a new one replaces it.
+func (r *Repository) downloadDatabase() error {
+	local, err := os.CreateTemp("", "capivara-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to create local database file: %w", err)
+	}
+	r.dbfile = local.Name()
+	local.Close()
+
+	db_file, err := r.Destination.OpenFile(DatabaseFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Info("No database file in remote storage, starting a new one")
+		return nil
+	}
+	if err != nil {
+		// an empty database would replace the snapshots on the next upload
+		return fmt.Errorf("error opening the database of the repository: %w", err)
+	}
+	defer db_file.Close()
+	log.Info("Database file already exists in remote storage")
+
+	reader, err := r.decrypt(db_file)
+	if err != nil {
+		return err
+	}
+	if err := copyToLocalFile(reader, r.dbfile); err != nil {
+		return fmt.Errorf("failed to write database file: %w", err)
+	}
+	return nil
+}
+
+// uploadDatabase writes the database next to the current one and renames it
+// over, so an interrupted upload never leaves a truncated database behind.
+func (r *Repository) uploadDatabase() error {
+	file, err := os.Open(r.dbfile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tmpname := DatabaseFileName + ".tmp"
+	writer, err := r.Destination.CreateFile(tmpname, "-rw-r--r--")
+	if err != nil {
+		return err
+	}
+	encrypted, err := r.encrypt(writer)
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	if _, err := io.Copy(encrypted, file); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := encrypted.Close(); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return r.Destination.RenameFile(tmpname, DatabaseFileName)
+}
+
+// encrypt wraps w so the data written is encrypted with the repository key,
+// closing the returned writer does not close w.
+func (r *Repository) encrypt(w io.Writer) (io.WriteCloser, error) {
+	if r.Key == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return r.Key.NewWriter(w)
+}
+
+func (r *Repository) decrypt(reader io.Reader) (io.Reader, error) {
+	if r.Key == nil {
+		return reader, nil
+	}
+	return r.Key.NewReader(reader)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func copyToLocalFile(reader io.Reader, filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
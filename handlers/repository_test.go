@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRepositoryDatabaseError(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0644))
+	origin := sources.NewLocalsource(dir)
+	destination := sources.NewLocalsource(t.TempDir())
+	require.NoError(t, Backup(origin, destination, sources.Setting{Jobs: 1}))
+
+	// a database that cannot be read is not replaced by an empty one
+	unreadable := &flakySource{Source: destination, fail: DatabaseFileName}
+	assert.ErrorContains(t, Backup(origin, unreadable, sources.Setting{Jobs: 1}), "disk error")
+	require.Len(t, snapshots(t, destination), 1)
+	require.NoError(t, Backup(origin, destination, sources.Setting{Jobs: 1}))
+	assert.Len(t, snapshots(t, destination), 2)
+}
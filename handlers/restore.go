@@ -1,110 +1,322 @@
 package handlers
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"os"
-	"uelei/capivara-sync/compressor"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"uelei/capivara-sync/db"
 	"uelei/capivara-sync/sources"
 )
 
-func Restore(origin sources.Source, destination sources.Source, snap_date string, clean bool) error {
-	database, er := GetDatabaseFromRemote(destination)
-	if er != nil {
-		log.Error("Error getting database from remote:", er)
+func Restore(origin sources.Source, destination sources.Source, snap_date string, clean bool, setting sources.Setting) (err error) {
+	plan := newPlan(setting)
+	// the dry run only plans the entries the origin can create
+	creator, _ := origin.(sources.EntryCreator)
+	var recorder *sources.DryRun
+	if setting.DryRun {
+		log.Warn("Dry run, the files will not be restored")
+		recorder = sources.NewDryRun(origin)
+		origin = recorder
 	}
 
-	defer func() {
-		log.Info("Clean up environment")
-		if err := database.Close(); err != nil {
-			log.Fatal("Error closing database:", err)
-		}
+	repo, er := OpenRepositoryShared(destination, setting)
+	if er != nil {
+		return fmt.Errorf("error opening repository: %w", er)
+	}
+	defer closeRepository(repo, &err)
+	database := repo.Database
 
-		db_file, _ := os.ReadFile("snapshot_files.db")
-		log.Info("Saving database file to remote storage")
-		saveerr := destination.SaveFile("snapshot_files.db", db_file, "-rw-r--r--")
-		if saveerr != nil {
-			log.Fatal("Error saving database file to local storage:", saveerr)
-		} else {
-			if err := os.Remove("snapshot_files.db"); err != nil {
-				log.Error("Error removing local database file:", err)
-			}
-		}
-	}()
 	var snapshotp *db.SnapShotRecord
-	var error error
 	if snap_date == "" {
 		log.Warning("SnapShot Date not provided, using the last snapshot")
-		snapshotp, error = db.GetLastSnap(database)
-		if error != nil {
-			log.Fatal("Error getting snapshot:", error)
+		snapshotp, err = db.GetLastSnap(database)
+		if err != nil {
+			return fmt.Errorf("error getting snapshot: %w", err)
 		}
 	} else {
 		log.Info("Searching SnapShot date:", snap_date)
-		snapshotp, error = db.GetSnapByDate(database, snap_date)
+		snapshotp, err = db.GetSnapByDate(database, snap_date)
 
-		if error != nil {
-			log.Fatal("Error getting snapshot:", error)
+		if err != nil {
+			return fmt.Errorf("error getting snapshot: %w", err)
 		}
 	}
+	if snapshotp == nil {
+		return fmt.Errorf("no complete snapshot found for date: %s", snap_date)
+	}
+	if interrupted, err := db.GetInterruptedSnap(database); err == nil && interrupted != nil {
+		log.Warn("Snapshot ", interrupted.Id, " of ", interrupted.Date, " is ", interrupted.Status, ", it is not restored")
+	}
 	snapshot := snapshotp
 	log.Info("Restoring snapshot ID: ", snapshot.Id, " Date: ", snapshot.Date)
 
 	files, err := db.ListFilesbySnapshot(database, snapshot.Id)
+	if err != nil {
+		return fmt.Errorf("error listing files of snapshot %d: %w", snapshot.Id, err)
+	}
+	if partialRestore(setting) {
+		files = selectRestore(files, setting)
+		if len(files) == 0 {
+			return fmt.Errorf("no file of snapshot %d is selected by the restore path, include patterns and strip prefix", snapshot.Id)
+		}
+		log.Info("Restoring ", len(files), " selected files")
+	}
 
+	// the files to clean are found before restoring so nothing is written
+	// when the clean is not confirmed
+	var extraneous []sources.FileInfo
 	if clean {
-
 		log.Warn("Clean Flag activated - removing all files in origin that are not in the snapshot")
+		extraneous = cleanCandidates(origin, files, setting)
+		if err := confirmClean(len(extraneous), setting); err != nil {
+			return err
+		}
+	}
+
+	var contents, entries []db.FileRecord
+	for _, file := range files {
+		if file.Type == "" || file.Type == sources.EntryFile {
+			contents = append(contents, file)
+		} else {
+			entries = append(entries, file)
+		}
+	}
 
-		localfiles := origin.ListFiles()
+	errs := &errorCollector{what: "restore"}
+	runOrdered(setting.Jobs, channelOf(contents), func(file db.FileRecord) error {
+		if err := repo.restoreFile(origin, file, plan, setting); err != nil {
+			return fmt.Errorf("%s: %w", file.Path, err)
+		}
+		return nil
+	}, func(err error) {
 		if err != nil {
-			log.Fatalf("Error listing files: %v", err)
+			log.Error("Error restoring file ", err)
+			errs.Add("", err)
 		}
+	})
+
+	// the clean prunes the directories it empties before the ones of the
+	// snapshot are created
+	if clean {
+		cleanFiles(origin, extraneous, setting, plan, errs)
+	}
+	repo.restoreEntries(origin, creator, entries, contents, plan, setting, errs)
 
-		for file := range localfiles {
+	if plan != nil {
+		plan.Print(os.Stdout, recorder.Operations())
+	}
+	return errs.Err()
 
-			found := false
-			for _, record := range files {
-				if record.MD5 == file.Md5 {
-					found = true
-					break
-				}
-			}
-			if !found {
-				log.Warn("removing the file from origin storage: ", file.Path)
-			}
+}
 
+// restoreFile writes a file of the snapshot to origin unless it already
+// holds the same content, then gives it back its metadata. On a dry run the
+// decision is only added to plan.
+func (r *Repository) restoreFile(origin sources.Source, file db.FileRecord, plan *Plan, setting sources.Setting) error {
+	log.Debug("Restoring file:", file.Path)
+	// Check if the file exists in the origin
+	exists := origin.Exists(file.Path)
+	hash, _ := origin.GetFileHash(file.Path)
+	if exists && hash == file.MD5 {
+		log.Debug("File already exists in origin storage ", file.Path)
+		plan.Add(PlanSkip, file.Path, "already restored", file.Size)
+		return restoreMetadata(origin, file, setting)
+	}
+	if plan != nil {
+		if exists {
+			plan.Add(PlanUpdate, file.Path, "content differs from the snapshot", file.Size)
+		} else {
+			plan.Add(PlanAdd, file.Path, "file does not exist", file.Size)
 		}
+		return nil
 	}
 
-	for _, file := range files {
-		log.Debug("Restoring file:", file.Path)
-		// Check if the file exists in the origin
-		exists := origin.Exists(file.Path)
-		hash, _ := origin.GetFileHash(file.Path)
-		if !exists || hash != file.MD5 {
-			// Get the file from the destination
-			data, err := destination.GetFile("block_" + file.MD5 + ".zst")
-			if err != nil {
-				log.Fatal("Error getting file:", err)
-			} else {
-				log.Info("File restored from destination storage")
-				datafile, error := compressor.DecompressZstd(data)
-				if error != nil {
-					log.Fatal("Error decompressing file:", error)
-				}
-				error = origin.SaveFile(file.Path, datafile, file.Permission)
-				if error != nil {
-					log.Fatal("Error saving file on local:", error)
-				}
-			}
-		} else {
+	// the content is written next to the file and checked before it
+	// replaces it, a failed restore leaves the file as it was
+	tmpname := file.Path + restoreSuffix
+	writer, err := origin.CreateFile(tmpname, file.Permission)
+	if err != nil {
+		return fmt.Errorf("error saving file on local: %w", err)
+	}
+	if err := r.restoreContent(file.MD5, writer); err != nil {
+		writer.Close()
+		removeTemp(origin, tmpname)
+		return fmt.Errorf("error getting file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		removeTemp(origin, tmpname)
+		return fmt.Errorf("error saving file on local: %w", err)
+	}
+	if err := origin.RenameFile(tmpname, file.Path); err != nil {
+		removeTemp(origin, tmpname)
+		return fmt.Errorf("error replacing file: %w", err)
+	}
+	if err := restoreMetadata(origin, file, setting); err != nil {
+		return err
+	}
+	log.Info("File restored from destination storage ", file.Path)
+	return nil
+}
 
-			log.Debug("File already exists in origin storage ", file.Path)
-		}
+// restoreSuffix is added to the name of a file while its content is
+// restored.
+const restoreSuffix = ".capivara-tmp"
 
+func removeTemp(origin sources.Source, name string) {
+	if err := origin.RemoveFile(name); err != nil {
+		log.Warn("Error removing ", name, ": ", err)
 	}
+}
 
+// restoreMetadata gives a restored file back the mode, times, owner and
+// extended attributes the snapshot recorded, on the sources that can set
+// them.
+func restoreMetadata(origin sources.Source, file db.FileRecord, setting sources.Setting) error {
+	setter, ok := origin.(sources.MetadataSetter)
+	if !ok {
+		return nil
+	}
+	meta := sources.Metadata{Mode: file.Mode, Modified: file.Modified, Accessed: file.Accessed, Owner: (*sources.Owner)(file.Owner),
+		Symlink: file.Type == sources.EntrySymlink}
+	if !setting.NoXattrs {
+		meta.Xattrs = file.Xattrs
+	}
+	if err := setter.SetMetadata(file.Path, meta, setting.NumericOwner); err != nil {
+		return fmt.Errorf("error restoring metadata: %w", err)
+	}
 	return nil
+}
+
+// restoreEntries creates the directories, links and special files of a
+// snapshot once its files are restored: the directories first and the hard
+// links last, then gives the directories their metadata, the deepest first
+// as writing in a directory changes its times.
+func (r *Repository) restoreEntries(origin sources.Source, creator sources.EntryCreator, entries []db.FileRecord, contents []db.FileRecord, plan *Plan, setting sources.Setting, errs *errorCollector) {
+	restored := make(map[string]bool, len(contents))
+	for _, file := range contents {
+		restored[file.Path] = true
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if a, b := entryOrder(entries[i].Type), entryOrder(entries[j].Type); a != b {
+			return a < b
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	var dirs []db.FileRecord
+	for _, entry := range entries {
+		if err := r.restoreEntry(origin, creator, entry, restored, plan, setting); err != nil {
+			log.Error("Error restoring ", entry.Type, " ", entry.Path, ": ", err)
+			errs.Add(entry.Path, err)
+		} else if entry.Type == sources.EntryDir && creator != nil {
+			dirs = append(dirs, entry)
+		}
+	}
+	if plan != nil {
+		return
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := restoreMetadata(origin, dirs[i], setting); err != nil {
+			errs.Add(dirs[i].Path, err)
+		}
+	}
+}
+
+func entryOrder(entryType string) int {
+	switch entryType {
+	case sources.EntryDir:
+		return 0
+	case sources.EntryHardlink:
+		return 2
+	}
+	return 1
+}
+
+// restoreEntry creates an entry other than a file. A hard link whose file is
+// not restored, or on an origin unable to create links, is restored as a
+// copy of its content.
+func (r *Repository) restoreEntry(origin sources.Source, creator sources.EntryCreator, entry db.FileRecord, restored map[string]bool, plan *Plan, setting sources.Setting) error {
+	if entry.Type == sources.EntryHardlink && (creator == nil || !restored[entry.LinkTarget]) {
+		return r.restoreFile(origin, entry, plan, setting)
+	}
+	if creator == nil && entry.Type == sources.EntryDir {
+		// the directories of the files are created with them
+		return nil
+	}
+	if creator == nil {
+		log.Warn("Not restoring ", entry.Type, " ", entry.Path, ", the target cannot create it")
+		plan.Add(PlanSkip, entry.Path, "the target cannot create a "+entry.Type, 0)
+		return nil
+	}
+	if plan != nil {
+		plan.Add(PlanAdd, entry.Path, "create "+entry.Type, 0)
+		return nil
+	}
+	err := creator.CreateEntry(sources.FileInfo{Path: entry.Path, Type: entry.Type, LinkTarget: entry.LinkTarget, Device: entry.Device, Mode: entry.Mode})
+	if err != nil && entry.Type == sources.EntryHardlink {
+		log.Warn("Error linking ", entry.Path, " to ", entry.LinkTarget, ", restoring a copy: ", err)
+		return r.restoreFile(origin, entry, plan, setting)
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("Created ", entry.Type, " ", entry.Path)
+	if entry.Type == sources.EntryDir {
+		return nil
+	}
+	return restoreMetadata(origin, entry, setting)
+}
+
+func partialRestore(setting sources.Setting) bool {
+	return setting.RestorePath != "" || len(setting.Include) > 0 || setting.StripPrefix != ""
+}
 
+// selectRestore returns the files of a snapshot a partial restore writes,
+// with the path they are restored to.
+func selectRestore(files []db.FileRecord, setting sources.Setting) []db.FileRecord {
+	var selected []db.FileRecord
+	for _, file := range SelectFiles(files, setting.RestorePath, setting.Include) {
+		target, ok := stripPrefix(file.Path, setting.StripPrefix)
+		if !ok {
+			log.Debug("Not restoring ", file.Path, ", it is outside of ", setting.StripPrefix)
+			continue
+		}
+		file.Path = target
+		if file.Type == sources.EntryHardlink {
+			// a hard link to a file outside the prefix is restored as a copy
+			file.LinkTarget, _ = stripPrefix(file.LinkTarget, setting.StripPrefix)
+		}
+		selected = append(selected, file)
+	}
+	return selected
+}
+
+// stripPrefix removes the prefix directory from a snapshot path, a prefix
+// naming the file itself leaves its name. It reports false for the paths
+// outside the prefix.
+func stripPrefix(name string, prefix string) (string, bool) {
+	prefix = strings.Trim(path.Clean("/"+prefix), "/")
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	switch {
+	case prefix == "":
+		return name, true
+	case name == prefix:
+		return path.Base(name), true
+	case strings.HasPrefix(name, prefix+"/"):
+		return strings.TrimPrefix(name, prefix+"/"), true
+	}
+	return "", false
+}
+
+// inRestoreScope reports whether a file of the restore target is one a
+// partial restore would select, every file is on a full restore.
+func inRestoreScope(target string, setting sources.Setting) bool {
+	if !partialRestore(setting) {
+		return true
+	}
+	original := path.Join(strings.Trim(setting.StripPrefix, "/"), filepath.ToSlash(target))
+	return len(SelectFiles([]db.FileRecord{{Path: original}}, setting.RestorePath, setting.Include)) == 1
 }
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestRestoreXattrs(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "photo.jpg")
+	require.NoError(t, os.WriteFile(name, []byte("jpeg"), 0644))
+	if err := unix.Setxattr(name, "user.camera", []byte("x100"), 0); err != nil {
+		t.Skip("no user extended attributes on this file system: ", err)
+	}
+
+	destination := sources.NewLocalsource(t.TempDir())
+	require.NoError(t, Backup(sources.NewLocalsource(dir), destination, sources.Setting{Jobs: 1, Origin: dir}))
+
+	target := t.TempDir()
+	require.NoError(t, Restore(sources.NewLocalsource(target), destination, "", false, sources.Setting{Jobs: 1}))
+	value := make([]byte, 16)
+	size, err := unix.Getxattr(filepath.Join(target, "photo.jpg"), "user.camera", value)
+	require.NoError(t, err)
+	assert.Equal(t, "x100", string(value[:size]))
+
+	skipped := t.TempDir()
+	require.NoError(t, Restore(sources.NewLocalsource(skipped), destination, "", false, sources.Setting{Jobs: 1, NoXattrs: true}))
+	_, err = unix.Getxattr(filepath.Join(skipped, "photo.jpg"), "user.camera", value)
+	assert.ErrorIs(t, err, unix.ENODATA)
+
+	// the namespaces not listed are not recorded
+	filtered := sources.WithXattrs(sources.NewLocalsource(dir), sources.Xattrs{Namespaces: []string{"security"}})
+	for file := range filtered.ListFiles() {
+		assert.Empty(t, file.Xattrs)
+	}
+}
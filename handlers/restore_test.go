@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripPrefix(t *testing.T) {
+	for _, c := range []struct{ name, prefix, want string }{
+		{"docs/a.txt", "", "docs/a.txt"},
+		{"docs/a.txt", "docs", "a.txt"},
+		{"docs/sub/a.txt", "/docs/", "sub/a.txt"},
+		{"docs/a.txt", "docs/a.txt", "a.txt"},
+	} {
+		got, ok := stripPrefix(c.name, c.prefix)
+		assert.True(t, ok, c.name)
+		assert.Equal(t, c.want, got, c.name)
+	}
+	_, ok := stripPrefix("docsold/a.txt", "docs")
+	assert.False(t, ok)
+}
+
+// restoredFiles lists the files under dir with slash separated paths.
+func restoredFiles(t *testing.T, dir string) []string {
+	var files []string
+	require.NoError(t, filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			relative, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(relative))
+		}
+		return err
+	}))
+	sort.Strings(files)
+	return files
+}
+
+func TestPartialRestore(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "docs/b.txt", "docs/c.jpg", "docs/old/d.txt", "photos/e.jpg"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0644))
+	}
+	destination := sources.NewLocalsource(t.TempDir())
+	require.NoError(t, Backup(sources.NewLocalsource(dir), destination, sources.Setting{Jobs: 2, Origin: dir}))
+
+	restore := func(setting sources.Setting) []string {
+		target := t.TempDir()
+		setting.Jobs = 2
+		require.NoError(t, Restore(sources.NewLocalsource(target), destination, "", false, setting))
+		return restoredFiles(t, target)
+	}
+	assert.Equal(t, []string{"docs/b.txt", "docs/c.jpg", "docs/old/d.txt"}, restore(sources.Setting{RestorePath: "docs"}))
+	assert.Equal(t, []string{"docs/c.jpg", "photos/e.jpg"}, restore(sources.Setting{Include: []string{"*.jpg"}}))
+	assert.Equal(t, []string{"b.txt", "old/d.txt"}, restore(sources.Setting{RestorePath: "docs", Include: []string{"*.txt"}, StripPrefix: "docs"}))
+	assert.Equal(t, []string{"d.txt"}, restore(sources.Setting{RestorePath: "docs/old/d.txt", StripPrefix: "docs/old"}))
+
+	target := t.TempDir()
+	err := Restore(sources.NewLocalsource(target), destination, "", false, sources.Setting{Jobs: 1, RestorePath: "missing"})
+	assert.ErrorContains(t, err, "no file of snapshot 1")
+	assert.Empty(t, restoredFiles(t, target))
+
+	// the relocated files hold the content of their snapshot path
+	require.NoError(t, Restore(sources.NewLocalsource(target), destination, "", false, sources.Setting{Jobs: 1, StripPrefix: "docs"}))
+	content, err := os.ReadFile(filepath.Join(target, "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "content of docs/b.txt", string(content))
+}
+
+func TestRestoreClean(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "docs/b.txt"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0644))
+	}
+	destination := sources.NewLocalsource(t.TempDir())
+	require.NoError(t, Backup(sources.NewLocalsource(dir), destination, sources.Setting{Jobs: 2, Origin: dir}))
+
+	target := t.TempDir()
+	extra := func() {
+		for name, content := range map[string]string{"docs/old/c.txt": "c", "e.txt": "e", "renamed.txt": "content of a.txt"} {
+			require.NoError(t, os.MkdirAll(filepath.Join(target, filepath.Dir(name)), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(target, name), []byte(content), 0644))
+		}
+	}
+	restore := func(setting sources.Setting) error {
+		setting.Jobs = 2
+		return Restore(sources.NewLocalsource(target), destination, "", true, setting)
+	}
+
+	// above the limit nothing is removed nor restored without a confirmation
+	extra()
+	err := restore(sources.Setting{CleanLimit: 2})
+	assert.ErrorContains(t, err, "would remove 4 files")
+	assert.Equal(t, []string{"docs/old/c.txt", "e.txt", "renamed.txt"}, restoredFiles(t, target))
+	err = restore(sources.Setting{CleanLimit: 2, Prompt: func(string, bool) (string, error) { return "n", nil }})
+	assert.ErrorContains(t, err, "cancelled")
+
+	// a renamed copy of a file of the snapshot is removed too
+	require.NoError(t, restore(sources.Setting{CleanLimit: 2, Trash: DefaultTrash, Prompt: func(string, bool) (string, error) { return "y", nil }}))
+	files := restoredFiles(t, target)
+	require.Len(t, files, 5)
+	assert.Equal(t, []string{"a.txt", "docs/b.txt"}, files[3:])
+	for _, file := range files[:3] {
+		assert.True(t, inDir(DefaultTrash, file), file)
+	}
+	assert.NoDirExists(t, filepath.Join(target, "docs", "old"))
+	assert.DirExists(t, filepath.Join(target, "docs"))
+
+	// the trashes are left out of the next backup of the target
+	extra()
+	require.NoError(t, restore(sources.Setting{Trash: "old-files"}))
+	assert.FileExists(t, filepath.Join(target, "old-files", sources.IgnoreFileName))
+	require.NoError(t, Backup(sources.WithFilter(sources.NewLocalsource(target), &sources.Filter{}), destination, sources.Setting{Jobs: 2, Origin: target}))
+	repo, err := OpenRepositoryReadOnly(destination, sources.Setting{})
+	require.NoError(t, err)
+	last, err := db.GetLastSnap(repo.Database)
+	require.NoError(t, err)
+	backedUp, err := db.ListFilesbySnapshot(repo.Database, last.Id)
+	require.NoError(t, err)
+	repo.Close()
+	for _, file := range backedUp {
+		assert.False(t, inDir(DefaultTrash, file.Path), file.Path)
+		assert.False(t, sources.HasContent(file.Type) && inDir("old-files", file.Path), file.Path)
+	}
+
+	// the trash is not cleaned, without it the files are deleted
+	extra()
+	require.NoError(t, restore(sources.Setting{}))
+	assert.Len(t, restoredFiles(t, target), 5)
+}
+
+func TestRestoreCleanKeepsExcluded(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "a", sources.IgnoreFileName: "cache/\n", "cache/x.bin": "x", "debug.log": "log"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	filtered := func() sources.Source {
+		filter := &sources.Filter{}
+		require.NoError(t, filter.Exclude("*.log"))
+		return sources.WithFilter(sources.NewLocalsource(dir), filter)
+	}
+	destination := sources.NewLocalsource(t.TempDir())
+	require.NoError(t, Backup(filtered(), destination, sources.Setting{Jobs: 2, Origin: dir}))
+
+	// the files the backup left out are not extraneous
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "e.txt"), []byte("e"), 0644))
+	require.NoError(t, Restore(filtered(), destination, "", true, sources.Setting{Jobs: 2}))
+	assert.Equal(t, []string{sources.IgnoreFileName, "a.txt", "cache/x.bin", "debug.log"}, restoredFiles(t, dir))
+}
+func TestRestoreKeepsFileOnError(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("backed up content"), 0644))
+	destination := sources.NewLocalsource(t.TempDir())
+	require.NoError(t, Backup(sources.NewLocalsource(dir), destination, sources.Setting{Jobs: 1}))
+
+	blocks, err := filepath.Glob(filepath.Join(destination.Localpath, "block_*"))
+	require.NoError(t, err)
+	require.NotEmpty(t, blocks)
+	for _, block := range blocks {
+		require.NoError(t, os.WriteFile(block, []byte("corrupt"), 0644))
+	}
+
+	// the file is only replaced once its content is restored and checked
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("local changes"), 0644))
+	assert.Error(t, Restore(sources.NewLocalsource(dir), destination, "", false, sources.Setting{Jobs: 1}))
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "local changes", string(data))
+	assert.Equal(t, []string{"a.txt"}, restoredFiles(t, dir))
+}
+
+func TestRestoreReleasesReaderLock(t *testing.T) {
+	destination := sources.NewLocalsource(t.TempDir())
+	err := Restore(sources.NewLocalsource(t.TempDir()), destination, "", false, sources.Setting{Jobs: 1})
+	assert.ErrorContains(t, err, "no complete snapshot found")
+	readers, err := readerLocks(destination)
+	require.NoError(t, err)
+	assert.Empty(t, readers)
+}
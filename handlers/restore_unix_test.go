@@ -0,0 +1,121 @@
+//go:build unix
+
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+	"uelei/capivara-sync/sources"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreMetadata(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "tool")
+	require.NoError(t, os.WriteFile(name, []byte("#!/bin/sh\n"), 0755))
+	// chown clears the setuid and setgid bits, it comes first
+	root := os.Geteuid() == 0
+	if root {
+		require.NoError(t, os.Lchown(name, 4321, 4321))
+	}
+	require.NoError(t, os.Chmod(name, 0755|os.ModeSetuid|os.ModeSetgid))
+	modified := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	accessed := modified.Add(time.Hour)
+	require.NoError(t, os.Chtimes(name, accessed, modified))
+
+	destination := sources.NewLocalsource(t.TempDir())
+	require.NoError(t, Backup(sources.NewLocalsource(dir), destination, sources.Setting{Jobs: 1, Origin: dir}))
+
+	target := t.TempDir()
+	require.NoError(t, Restore(sources.NewLocalsource(target), destination, "", false, sources.Setting{Jobs: 1, NumericOwner: true}))
+	info, err := os.Stat(filepath.Join(target, "tool"))
+	require.NoError(t, err)
+	assert.Equal(t, 0755|os.ModeSetuid|os.ModeSetgid, info.Mode())
+	assert.True(t, modified.Equal(info.ModTime()), info.ModTime())
+	stat := info.Sys().(*syscall.Stat_t)
+	if root {
+		assert.Equal(t, []uint32{4321, 4321}, []uint32{stat.Uid, stat.Gid})
+	}
+
+	// a file already restored gets its metadata back too
+	require.NoError(t, os.Chmod(filepath.Join(target, "tool"), 0600))
+	require.NoError(t, os.Chtimes(filepath.Join(target, "tool"), time.Now(), time.Now()))
+	require.NoError(t, Restore(sources.NewLocalsource(target), destination, "", false, sources.Setting{Jobs: 1, NumericOwner: true}))
+	info, err = os.Stat(filepath.Join(target, "tool"))
+	require.NoError(t, err)
+	assert.Equal(t, 0755|os.ModeSetuid|os.ModeSetgid, info.Mode())
+	assert.True(t, modified.Equal(info.ModTime()), info.ModTime())
+}
+
+func TestRestoreEntries(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "empty"), 0700))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "docs"), 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "a.txt"), []byte("shared content"), 0644))
+	require.NoError(t, os.Link(filepath.Join(dir, "docs", "a.txt"), filepath.Join(dir, "docs", "b.txt")))
+	require.NoError(t, os.Symlink("docs/a.txt", filepath.Join(dir, "link")))
+	require.NoError(t, syscall.Mkfifo(filepath.Join(dir, "pipe"), 0640))
+	modified := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "docs"), modified, modified))
+
+	destination := sources.NewLocalsource(t.TempDir())
+	require.NoError(t, Backup(sources.NewLocalsource(dir), destination, sources.Setting{Jobs: 2, Origin: dir}))
+
+	target := t.TempDir()
+	require.NoError(t, Restore(sources.NewLocalsource(target), destination, "", false, sources.Setting{Jobs: 2}))
+
+	info, err := os.Stat(filepath.Join(target, "empty"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0700, info.Mode())
+	info, err = os.Stat(filepath.Join(target, "docs"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0750, info.Mode())
+	assert.True(t, modified.Equal(info.ModTime()), info.ModTime())
+
+	a, err := os.Stat(filepath.Join(target, "docs", "a.txt"))
+	require.NoError(t, err)
+	b, err := os.Stat(filepath.Join(target, "docs", "b.txt"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(a, b), "b.txt is a hard link of a.txt")
+
+	linkTarget, err := os.Readlink(filepath.Join(target, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "docs/a.txt", linkTarget)
+	info, err = os.Lstat(filepath.Join(target, "pipe"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeNamedPipe|0640, info.Mode())
+
+	// a hard link whose file is not restored is restored as a copy
+	partial := t.TempDir()
+	require.NoError(t, Restore(sources.NewLocalsource(partial), destination, "", false, sources.Setting{Jobs: 1, Include: []string{"b.txt"}}))
+	data, err := os.ReadFile(filepath.Join(partial, "docs", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "shared content", string(data))
+}
+
+func TestRestoreCleanEntries(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "kept"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0644))
+	destination := sources.NewLocalsource(t.TempDir())
+	require.NoError(t, Backup(sources.NewLocalsource(dir), destination, sources.Setting{Jobs: 1, Origin: dir}))
+
+	target := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(target, "empty", "nested"), 0755))
+	require.NoError(t, os.Symlink("a.txt", filepath.Join(target, "link")))
+	require.NoError(t, syscall.Mkfifo(filepath.Join(target, "pipe"), 0644))
+	require.NoError(t, Restore(sources.NewLocalsource(target), destination, "", true, sources.Setting{Jobs: 1}))
+
+	entries, err := os.ReadDir(target)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"a.txt", "kept"}, names)
+}
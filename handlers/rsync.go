@@ -2,11 +2,20 @@ package handlers
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"uelei/capivara-sync/sources"
 )
 import log "github.com/sirupsen/logrus"
 
-func RSync(origin sources.Source, destination sources.Source, delete bool) error {
+func RSync(origin sources.Source, destination sources.Source, delete bool, setting sources.Setting) error {
+	plan := newPlan(setting)
+	var recorder *sources.DryRun
+	if setting.DryRun {
+		log.Warn("Dry run, the destination will not be changed")
+		recorder = sources.NewDryRun(destination)
+		destination = recorder
+	}
 
 	files := origin.ListFiles()
 
@@ -18,6 +27,7 @@ func RSync(origin sources.Source, destination sources.Source, delete bool) error
 			oexists := origin.Exists(file.Path)
 			if !oexists {
 				log.Error("File not found in origin, removing from destination: ", file.Path)
+				plan.Add(PlanDelete, file.Path, "not in the origin", file.Size)
 				error := destination.RemoveFile(file.Path)
 				if error != nil {
 					log.Error("Error removing file from destination:", error)
@@ -26,56 +36,90 @@ func RSync(origin sources.Source, destination sources.Source, delete bool) error
 		}
 	}
 	log.Info("Syncing files from origin to destination")
-	for file := range files {
-		var error error
-		log.Debug("file : ", file.Path, " MD5: ", file.Md5, " Filename: ", file.Filename, " LT : ", file.LastModified)
-		exists := destination.Exists(file.Path)
-		reason := ""
-		remote_hash := ""
-		if exists {
-			// get remote hash
-			remote_hash, error = destination.GetFileHash(file.Path)
-			if error != nil {
-				log.Error("Error getting file hash:", error)
-			}
-			if remote_hash != file.Md5 {
-
-				last_modified, error := destination.GetFileLastModified(file.Path)
-				if error != nil {
-					log.Error("Error getting file last modified:", error)
+	errs := &errorCollector{what: "sync"}
+	runOrdered(setting.Jobs, files, func(file sources.FileInfo) error {
+		return syncFile(origin, destination, file, plan)
+	}, func(err error) {
+		if err != nil {
+			log.Error("Error saving file to remote storage:", err)
+			errs.Add("", err)
+		}
+	})
 
-				}
-				log.Info("local hash is :", file.Md5, " remote_hash is : ", remote_hash)
-				if last_modified.After(file.LastModified) {
+	if plan != nil {
+		plan.Print(os.Stdout, recorder.Operations())
+	}
+	return errs.Err()
+}
 
-					log.Warn("The File: ", file.Path, " is older: ", TimeToString(file.LastModified), " then remote: ", TimeToString(last_modified))
-					reason = ""
-				} else {
-					reason = "Remote file hash does not match."
-				}
-			} else {
-				log.Debug("File already exists in remote storage, skipping upload. " + file.Path)
-			}
-		} else {
-			reason = "File does not exist in remote storage."
+// syncFile copies a file to destination unless it holds the same or a newer one.
+func syncFile(origin sources.Source, destination sources.Source, file sources.FileInfo, plan *Plan) error {
+	var error error
+	log.Debug("file : ", file.Path, " MD5: ", file.Md5, " Filename: ", file.Filename, " LT : ", file.LastModified)
+	exists := destination.Exists(file.Path)
+	reason := ""
+	remote_hash := ""
+	if exists {
+		// get remote hash
+		remote_hash, error = destination.GetFileHash(file.Path)
+		if error != nil {
+			log.Error("Error getting file hash:", error)
 		}
+		if remote_hash != file.Md5 {
 
-		if reason != "" {
-			log.Info("Sync up file: ", file.Path, " — reason: ", reason)
-			origin_file_bytes, error := origin.GetFile(file.Path)
+			last_modified, error := destination.GetFileLastModified(file.Path)
 			if error != nil {
-				fmt.Println("Error getting file:", error)
+				log.Error("Error getting file last modified:", error)
+
 			}
+			log.Info("local hash is :", file.Md5, " remote_hash is : ", remote_hash)
+			if last_modified.After(file.LastModified) {
 
-			log.Info("Writing file to remote:", file.Path)
-			if err := destination.SaveFile(file.Path, origin_file_bytes, "-rw-r--r--"); err != nil {
-				log.Error("Error saving file to remote storage:", err)
+				log.Warn("The File: ", file.Path, " is older: ", TimeToString(file.LastModified), " then remote: ", TimeToString(last_modified))
+				reason = ""
+				plan.Add(PlanSkip, file.Path, "remote file is newer", file.Size)
 			} else {
-				log.Debug("File saved to remote storage successfully")
+				reason = "Remote file hash does not match."
 			}
+		} else {
+			log.Debug("File already exists in remote storage, skipping upload. " + file.Path)
+			plan.Add(PlanSkip, file.Path, "already in sync", file.Size)
 		}
-		log.Debug("File synced successfully " + file.Path)
+	} else {
+		reason = "File does not exist in remote storage."
 	}
 
+	if reason != "" {
+		if exists {
+			plan.Add(PlanUpdate, file.Path, reason, file.Size)
+		} else {
+			plan.Add(PlanAdd, file.Path, reason, file.Size)
+		}
+		log.Info("Sync up file: ", file.Path, " — reason: ", reason)
+		log.Info("Writing file to remote:", file.Path)
+		if err := copyFile(origin, destination, file.Path); err != nil {
+			return fmt.Errorf("%s: %w", file.Path, err)
+		}
+		log.Debug("File saved to remote storage successfully")
+	}
+	log.Debug("File synced successfully " + file.Path)
 	return nil
 }
+
+func copyFile(origin sources.Source, destination sources.Source, path string) error {
+	reader, err := origin.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := destination.CreateFile(path, "-rw-r--r--")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"uelei/capivara-sync/db"
+	"uelei/capivara-sync/sources"
+)
+
+// ListSnapshots prints the snapshots of the repository the filter selects.
+func ListSnapshots(destination sources.Source, filter db.SnapshotFilter, setting sources.Setting) (err error) {
+	repo, err := OpenRepositoryReadOnly(destination, setting)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %w", err)
+	}
+	defer closeRepository(repo, &err)
+
+	snaps, err := db.ListSnapShots(repo.Database)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots: %w", err)
+	}
+	PrintSnapshots(os.Stdout, db.FilterSnapshots(snaps, filter))
+	return nil
+}
+
+// PrintSnapshots writes a table of the snapshots and their metadata.
+func PrintSnapshots(w io.Writer, snaps []db.SnapShotRecord) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tDATE\tSTATUS\tHOST\tUSER\tORIGIN\tTAGS\tFILES\tSIZE\tNEW\tDURATION\tDESCRIPTION")
+	incomplete := 0
+	for _, snap := range snaps {
+		if !snap.Complete() {
+			incomplete++
+		}
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			snap.Id, snap.Date, snap.Status, orDash(snap.Hostname), orDash(snap.User), orDash(snap.Origin),
+			orDash(strings.Join(snap.Tags, ",")), snap.FileCount, FormatBytes(snap.TotalBytes),
+			FormatBytes(snap.NewBytes), formatDuration(snap.Duration), snap.Description)
+	}
+	table.Flush()
+	fmt.Fprintf(w, "%d snapshots", len(snaps))
+	if incomplete > 0 {
+		fmt.Fprintf(w, ", %d incomplete are not restored unless resumed with backup --resume", incomplete)
+	}
+	fmt.Fprintln(w)
+}
+
+// orDash shows the metadata older snapshots do not have as "-".
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second / 10).String()
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// runOrdered calls work for every item read from in on jobs goroutines and
+// hands the results to collect one at a time, in the order of the input, so
+// whatever collect writes does not depend on which worker finished first.
+func runOrdered[T any, R any](jobs int, in <-chan T, work func(T) R, collect func(R)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	type indexed struct {
+		index int
+		item  T
+	}
+	type output struct {
+		index  int
+		result R
+	}
+
+	items := make(chan indexed)
+	results := make(chan output, jobs)
+	go func() {
+		defer close(items)
+		index := 0
+		for item := range in {
+			items <- indexed{index, item}
+			index++
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range items {
+				results <- output{it.index, work(it.item)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := map[int]R{}
+	next := 0
+	for out := range results {
+		pending[out.index] = out.result
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			collect(result)
+			next++
+		}
+	}
+}
+
+// channelOf feeds a slice to runOrdered.
+func channelOf[T any](items []T) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for _, item := range items {
+			ch <- item
+		}
+	}()
+	return ch
+}
+
+// errorCollector gathers the errors of the files that failed so the others
+// carry on, Err reports them all at the end.
+type errorCollector struct {
+	mu   sync.Mutex
+	what string
+	errs []error
+}
+
+func (c *errorCollector) Add(path string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if path != "" {
+		err = fmt.Errorf("%s: %w", path, err)
+	}
+	c.errs = append(c.errs, err)
+}
+
+func (c *errorCollector) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d files failed to %s: %w", len(c.errs), c.what, errors.Join(c.errs...))
+}
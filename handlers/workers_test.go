@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunOrderedKeepsInputOrder(t *testing.T) {
+	items := []int{5, 1, 4, 2, 3, 0}
+	var got []int
+	runOrdered(3, channelOf(items), func(i int) int {
+		// later items finish first
+		time.Sleep(time.Duration(i) * time.Millisecond)
+		return i * 10
+	}, func(r int) {
+		got = append(got, r)
+	})
+	assert.Equal(t, []int{50, 10, 40, 20, 30, 0}, got)
+}
+
+func TestErrorCollector(t *testing.T) {
+	errs := &errorCollector{what: "back up"}
+	assert.NoError(t, errs.Err())
+
+	errs.Add("a.txt", errors.New("boom"))
+	errs.Add("b.txt", errors.New("bang"))
+	err := errs.Err()
+	assert.ErrorContains(t, err, "2 files failed to back up")
+	assert.ErrorContains(t, err, "a.txt: boom")
+	assert.ErrorContains(t, err, "b.txt: bang")
+}
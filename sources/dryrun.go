@@ -0,0 +1,82 @@
+package sources
+
+import (
+	"io"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Operation is a change a DryRun source was asked to make.
+type Operation struct {
+	Kind string // write, remove or rename
+	Path string
+	Size int64
+}
+
+// DryRun wraps a source so it can be read as usual while the calls that
+// would change it are only recorded.
+type DryRun struct {
+	Source
+	mu         sync.Mutex
+	operations []Operation
+}
+
+func NewDryRun(source Source) *DryRun {
+	return &DryRun{Source: source}
+}
+
+// Operations returns the changes recorded so far, in the order they were made.
+func (d *DryRun) Operations() []Operation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Operation{}, d.operations...)
+}
+
+func (d *DryRun) record(kind string, path string, size int64) {
+	log.Debug("Dry run, not doing ", kind, " ", path)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.operations = append(d.operations, Operation{Kind: kind, Path: path, Size: size})
+}
+
+func (d *DryRun) SaveFile(path string, data []byte, perm string) error {
+	d.record("write", path, int64(len(data)))
+	return nil
+}
+
+func (d *DryRun) CreateFile(path string, perm string) (io.WriteCloser, error) {
+	return &dryRunWriter{source: d, path: path}, nil
+}
+
+func (d *DryRun) RemoveFile(path string) error {
+	d.record("remove", path, 0)
+	return nil
+}
+
+func (d *DryRun) RemoveDir(path string) error {
+	d.record("remove", path+"/", 0)
+	return nil
+}
+
+func (d *DryRun) RenameFile(oldpath string, newpath string) error {
+	d.record("rename", oldpath+" -> "+newpath, 0)
+	return nil
+}
+
+// dryRunWriter discards what is written, counting it for the record.
+type dryRunWriter struct {
+	source *DryRun
+	path   string
+	size   int64
+}
+
+func (w *dryRunWriter) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+func (w *dryRunWriter) Close() error {
+	w.source.record("write", w.path, w.size)
+	return nil
+}
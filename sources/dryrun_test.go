@@ -0,0 +1,35 @@
+package sources
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunRecordsChanges(t *testing.T) {
+	ls := Localsource{Localpath: t.TempDir() + "/"}
+	assert.NoError(t, ls.SaveFile("kept.txt", []byte("kept"), "-rw-r--r--"))
+
+	dry := NewDryRun(ls)
+	assert.NoError(t, dry.SaveFile("new.txt", []byte("new"), "-rw-r--r--"))
+	writer, err := dry.CreateFile("streamed.txt", "-rw-r--r--")
+	assert.NoError(t, err)
+	_, err = writer.Write([]byte("streamed"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, dry.RemoveFile("kept.txt"))
+	assert.NoError(t, dry.RenameFile("kept.txt", "moved.txt"))
+
+	assert.Equal(t, []Operation{
+		{Kind: "write", Path: "new.txt", Size: 3},
+		{Kind: "write", Path: "streamed.txt", Size: 8},
+		{Kind: "remove", Path: "kept.txt"},
+		{Kind: "rename", Path: "kept.txt -> moved.txt"},
+	}, dry.Operations())
+
+	// reads still reach the wrapped source, which is left untouched
+	assert.True(t, dry.Exists("kept.txt"))
+	assert.False(t, ls.Exists("new.txt"))
+	assert.False(t, ls.Exists("streamed.txt"))
+	assert.False(t, ls.Exists("moved.txt"))
+}
@@ -0,0 +1,85 @@
+package sources
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Entry types of FileInfo.Type, an empty type is a regular file.
+const (
+	EntryFile     = "file"
+	EntryDir      = "dir"
+	EntrySymlink  = "symlink"
+	EntryHardlink = "hardlink"
+	EntryFifo     = "fifo"
+	EntryDevice   = "device"
+)
+
+// HasContent tells whether the entry type is stored as blocks: files and the
+// hard links sharing their content.
+func HasContent(entryType string) bool {
+	return entryType == "" || entryType == EntryFile || entryType == EntryHardlink
+}
+
+// EntryLister is a source that can list all its entries, not only the files
+// ListFiles returns: the directories, the symbolic links with their target,
+// the fifos and the devices. The files sharing an inode are listed once as a
+// file, then as hard links to its path.
+type EntryLister interface {
+	ListEntries() <-chan FileInfo
+}
+
+// EntryCreator is a source that can create the entries ListEntries lists
+// other than files, replacing what is at their path unless both are
+// directories.
+type EntryCreator interface {
+	CreateEntry(entry FileInfo) error
+}
+
+// entryType returns the entry type of a local file mode, "" for the sockets
+// and other files a backup cannot recreate.
+func entryType(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return EntryFile
+	case mode.IsDir():
+		return EntryDir
+	case mode&os.ModeSymlink != 0:
+		return EntrySymlink
+	case mode&os.ModeNamedPipe != 0:
+		return EntryFifo
+	case mode&os.ModeDevice != 0:
+		return EntryDevice
+	}
+	return ""
+}
+
+// CreateEntry makes a directory, a link or a special file.
+func (l Localsource) CreateEntry(entry FileInfo) error {
+	name := l.Localpath + entry.Path
+	if entry.Type == EntryDir {
+		// writable until SetMetadata gives the directory its mode
+		return os.MkdirAll(name, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if existing, err := os.Lstat(name); err == nil {
+		if existing.IsDir() {
+			return fmt.Errorf("%s is a directory", entry.Path)
+		}
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	switch entry.Type {
+	case EntrySymlink:
+		return os.Symlink(entry.LinkTarget, name)
+	case EntryHardlink:
+		return os.Link(l.Localpath+entry.LinkTarget, name)
+	case EntryFifo, EntryDevice:
+		return makeSpecial(name, entry.Mode, entry.Device)
+	}
+	return fmt.Errorf("cannot create %s of type %q", entry.Path, entry.Type)
+}
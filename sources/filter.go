@@ -0,0 +1,337 @@
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// IgnoreFileName is the file holding exclude patterns for the directory it
+// is in and the ones below, like a .gitignore.
+const IgnoreFileName = ".capivaraignore"
+
+// TrashDir is the directory restore --clean moves the files it removes to
+// by default, it is never listed.
+const TrashDir = ".capivara-trash"
+
+// Filter decides which files ListFiles returns. Patterns follow the
+// .gitignore syntax and the last one matching a path wins, so a pattern
+// starting with ! includes again what an earlier one excluded. Files in an
+// excluded directory are never included again.
+type Filter struct {
+	rules []rule
+	// MinSize and MaxSize bound the size of the files kept, 0 is no bound.
+	MinSize int64
+	MaxSize int64
+	// NewerThan and OlderThan bound the modification time of the files
+	// kept, the zero time is no bound.
+	NewerThan time.Time
+	OlderThan time.Time
+}
+
+type rule struct {
+	pattern *regexp.Regexp
+	include bool
+	dirOnly bool
+}
+
+// Exclude adds a pattern in the .gitignore syntax.
+func (f *Filter) Exclude(pattern string) error {
+	r, err := parseRule(pattern)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		f.rules = append(f.rules, *r)
+	}
+	return nil
+}
+
+// ExcludeFrom adds the patterns of a file, one per line.
+func (f *Filter) ExcludeFrom(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	rules, err := parseRules(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	f.rules = append(f.rules, rules...)
+	return nil
+}
+
+func parseRules(data []byte) ([]rule, error) {
+	var rules []rule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		r, err := parseRule(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			rules = append(rules, *r)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// parseRule compiles a .gitignore line, blank lines and comments are nil.
+func parseRule(line string) (*rule, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	r := &rule{}
+	if strings.HasPrefix(line, "!") {
+		r.include = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// a pattern with a slash is relative to the base, one without matches
+	// a name at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return nil, nil
+	}
+
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(.*/)?" + expr + "$"
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", line, err)
+	}
+	r.pattern = pattern
+	return r, nil
+}
+
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// WithFilter returns the source listing only the files the filter keeps.
+func WithFilter(source Source, filter *Filter) Source {
+	switch s := source.(type) {
+	case Localsource:
+		s.Filter = filter
+		return s
+	case *SSHSource:
+		s.Filter = filter
+	case *WebDAVSource:
+		s.Filter = filter
+	case *S3Source:
+		s.Filter = filter
+	}
+	return source
+}
+
+// FilterWalk applies a filter to one listing of a source, it holds the
+// .capivaraignore patterns found along the way. A nil FilterWalk keeps
+// everything.
+type FilterWalk struct {
+	filter *Filter
+	mu     sync.Mutex
+	// ignores maps a directory, "" for the base, to the patterns of its
+	// ignore file
+	ignores map[string][]rule
+}
+
+// Walk starts a listing, a nil filter returns a nil FilterWalk.
+func (f *Filter) Walk() *FilterWalk {
+	if f == nil {
+		return nil
+	}
+	return &FilterWalk{filter: f, ignores: map[string][]rule{}}
+}
+
+// AddIgnoreFile adds the patterns of the ignore file of dir.
+func (w *FilterWalk) AddIgnoreFile(dir string, data []byte) {
+	if w == nil {
+		return
+	}
+	rules, err := parseRules(data)
+	if err != nil {
+		log.Warn("Ignoring ", path.Join(dir, IgnoreFileName), ": ", err)
+		return
+	}
+	dir = strings.Trim(dir, "/")
+	if dir == "." {
+		dir = ""
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ignores[dir] = rules
+}
+
+// SkipDir reports whether a directory is excluded with everything in it.
+func (w *FilterWalk) SkipDir(dir string) bool {
+	dir = strings.Trim(dir, "/")
+	if dir == TrashDir {
+		return true
+	}
+	if w == nil {
+		return false
+	}
+	return w.excluded(dir, true)
+}
+
+// Keep reports whether a file is listed, its directories included.
+func (w *FilterWalk) Keep(file string, size int64, modified time.Time) bool {
+	if w != nil {
+		if f := w.filter; (f.MinSize > 0 && size < f.MinSize) || (f.MaxSize > 0 && size > f.MaxSize) {
+			return false
+		}
+	}
+	return w.KeepEntry(file, modified)
+}
+
+// KeepEntry is Keep for the links and special files, whose size is not
+// the one of a content.
+func (w *FilterWalk) KeepEntry(file string, modified time.Time) bool {
+	if strings.HasPrefix(strings.TrimLeft(file, "/"), TrashDir+"/") {
+		return false
+	}
+	if w == nil {
+		return true
+	}
+	f := w.filter
+	if !f.NewerThan.IsZero() && modified.Before(f.NewerThan) {
+		return false
+	}
+	if !f.OlderThan.IsZero() && modified.After(f.OlderThan) {
+		return false
+	}
+
+	file = strings.Trim(file, "/")
+	for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+		if w.excluded(dir, true) {
+			return false
+		}
+	}
+	return !w.excluded(file, false)
+}
+
+// excluded applies the patterns of the ignore files from the base down to
+// the directory of name, then the ones of the filter, to name alone.
+func (w *FilterWalk) excluded(name string, isDir bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	excluded := false
+	apply := func(rules []rule, relative string) {
+		for _, r := range rules {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if r.pattern.MatchString(relative) {
+				excluded = !r.include
+			}
+		}
+	}
+
+	apply(w.ignores[""], name)
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		if rules, ok := w.ignores[dir]; ok {
+			apply(rules, strings.Join(parts[i:], "/"))
+		}
+	}
+	apply(w.filter.rules, name)
+	return excluded
+}
+
+// ParseSize parses a size like 512, 10K, 1.5M or 2G, the units are powers
+// of 1024 and an optional trailing B or iB is accepted.
+func ParseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if s != "" {
+		if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			s = s[:len(s)-1]
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// ParseAge parses a duration accepting days (7d) and weeks (2w) on top of
+// the units of time.ParseDuration.
+func ParseAge(value string) (time.Duration, error) {
+	s := strings.TrimSpace(value)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid age %q", value)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+	return age, nil
+}
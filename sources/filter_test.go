@@ -0,0 +1,138 @@
+package sources
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func filterOf(t *testing.T, patterns ...string) *Filter {
+	f := &Filter{}
+	for _, pattern := range patterns {
+		assert.NoError(t, f.Exclude(pattern))
+	}
+	return f
+}
+
+func TestFilterPatterns(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		patterns []string
+		path     string
+		keep     bool
+	}{
+		{[]string{"*.tmp"}, "a.tmp", false},
+		{[]string{"*.tmp"}, "dir/sub/a.tmp", false},
+		{[]string{"*.tmp"}, "a.tmpx", true},
+		{[]string{"node_modules/"}, "web/node_modules/x/index.js", false},
+		{[]string{"node_modules/"}, "node_modules", true},
+		{[]string{"/build"}, "build/out.bin", false},
+		{[]string{"/build"}, "src/build/out.bin", true},
+		{[]string{"docs/*.md"}, "docs/a.md", false},
+		{[]string{"docs/*.md"}, "docs/sub/a.md", true},
+		{[]string{"docs/**/*.md"}, "docs/sub/deep/a.md", false},
+		{[]string{"**/cache"}, "a/b/cache/file", false},
+		{[]string{"logs/**"}, "logs/2024/app.log", false},
+		{[]string{"*.log", "!keep.log"}, "keep.log", true},
+		{[]string{"*.log", "!keep.log"}, "drop.log", false},
+		{[]string{"tmp/", "!tmp/keep"}, "tmp/keep", false},
+		{[]string{"file[0-9].txt"}, "file5.txt", false},
+		{[]string{"file[!0-9].txt"}, "file5.txt", true},
+		{[]string{"# comment", "", `\#hash`}, "#hash", false},
+		{[]string{"*", "!*/", "!*.go"}, "pkg/main.go", true},
+		{[]string{"*", "!*/", "!*.go"}, "pkg/readme.md", false},
+	}
+	for _, c := range cases {
+		walk := filterOf(t, c.patterns...).Walk()
+		assert.Equal(t, c.keep, walk.Keep(c.path, 1, now), "%v on %s", c.patterns, c.path)
+	}
+}
+
+func TestFilterSizeAndAge(t *testing.T) {
+	now := time.Now()
+	f := &Filter{MinSize: 10, MaxSize: 100, NewerThan: now.Add(-48 * time.Hour), OlderThan: now.Add(-time.Hour)}
+	walk := f.Walk()
+	assert.True(t, walk.Keep("a", 50, now.Add(-2*time.Hour)))
+	assert.False(t, walk.Keep("a", 5, now.Add(-2*time.Hour)))
+	assert.False(t, walk.Keep("a", 500, now.Add(-2*time.Hour)))
+	assert.False(t, walk.Keep("a", 50, now.Add(-72*time.Hour)))
+	assert.False(t, walk.Keep("a", 50, now))
+
+	// links and special files are not filtered by size
+	assert.True(t, walk.KeepEntry("a", now.Add(-2*time.Hour)))
+	assert.False(t, walk.KeepEntry("a", now))
+
+	var none *Filter
+	assert.True(t, none.Walk().Keep("anything", 0, now))
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	walk := filterOf(t, "!sub/important.tmp").Walk()
+	walk.AddIgnoreFile("", []byte("*.tmp\n"))
+	walk.AddIgnoreFile("sub", []byte("/local\n!keep.tmp\n"))
+
+	assert.False(t, walk.Keep("a.tmp", 1, time.Now()))
+	assert.True(t, walk.Keep("sub/keep.tmp", 1, time.Now()))
+	assert.True(t, walk.Keep("sub/important.tmp", 1, time.Now()))
+	assert.False(t, walk.Keep("sub/other.tmp", 1, time.Now()))
+	assert.True(t, walk.SkipDir("sub/local"))
+	assert.False(t, walk.SkipDir("local"))
+}
+
+func TestLocalListFilesFiltered(t *testing.T) {
+	base := t.TempDir() + "/"
+	for name, content := range map[string]string{
+		"a.txt":                 "a",
+		"a.tmp":                 "tmp",
+		"node_modules/pkg/x.js": "x",
+		"sub/" + IgnoreFileName: "secret*\n",
+		"sub/secret.key":        "key",
+		"sub/b.txt":             "b",
+		"big.bin":               "0123456789",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(base+name), 0755))
+		assert.NoError(t, os.WriteFile(base+name, []byte(content), 0644))
+	}
+
+	filter := filterOf(t, "*.tmp", "node_modules/")
+	filter.MaxSize = 5
+	source := WithFilter(Localsource{Localpath: base}, filter)
+
+	var listed []string
+	for file := range source.ListFiles() {
+		listed = append(listed, file.Path)
+	}
+	sort.Strings(listed)
+	assert.Equal(t, []string{"a.txt", "sub/b.txt"}, listed)
+
+	// the ignore file itself is larger than the size limit
+	filter.MaxSize = 0
+	listed = nil
+	for file := range source.ListFiles() {
+		listed = append(listed, file.Path)
+	}
+	sort.Strings(listed)
+	assert.Equal(t, []string{"a.txt", "big.bin", "sub/" + IgnoreFileName, "sub/b.txt"}, listed)
+}
+
+func TestParseSizeAndAge(t *testing.T) {
+	for value, expected := range map[string]int64{"512": 512, "10K": 10 << 10, "1.5M": 3 << 19, "2GiB": 2 << 30, "1kb": 1024} {
+		size, err := ParseSize(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, size, value)
+	}
+	_, err := ParseSize("ten")
+	assert.Error(t, err)
+
+	for value, expected := range map[string]time.Duration{"7d": 7 * 24 * time.Hour, "2w": 14 * 24 * time.Hour, "90m": 90 * time.Minute} {
+		age, err := ParseAge(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, age, value)
+	}
+	_, err = ParseAge("yesterday")
+	assert.Error(t, err)
+}
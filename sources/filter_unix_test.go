@@ -0,0 +1,33 @@
+//go:build unix
+
+package sources
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalListEntriesSizeFilter(t *testing.T) {
+	base := t.TempDir() + "/"
+	require.NoError(t, os.WriteFile(base+"small.txt", []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(base+"big.txt", []byte("0123456789"), 0644))
+	require.NoError(t, os.Symlink("a/target/longer/than/the/limits", filepath.Join(base, "long-link")))
+	require.NoError(t, os.Symlink("s", filepath.Join(base, "short-link")))
+
+	list := func(filter *Filter) []string {
+		var listed []string
+		for entry := range WithFilter(Localsource{Localpath: base}, filter).(Localsource).ListEntries() {
+			listed = append(listed, entry.Path)
+		}
+		sort.Strings(listed)
+		return listed
+	}
+	// the links are kept whatever the length of their target
+	assert.Equal(t, []string{"long-link", "short-link", "small.txt"}, list(&Filter{MaxSize: 5}))
+	assert.Equal(t, []string{"big.txt", "long-link", "short-link"}, list(&Filter{MinSize: 5}))
+}
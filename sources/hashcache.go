@@ -0,0 +1,148 @@
+package sources
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HashCache is the file index of a local origin kept between backups: the
+// hash of every file with its inode, size, modification and change times.
+// The listings reuse the hash of the files whose index entry did not change
+// instead of reading them again.
+type HashCache struct {
+	// Path is the JSON file holding the index.
+	Path string
+	// Rehash reads every file again, the index is only rewritten.
+	Rehash bool
+}
+
+// cachedHash is the index entry of a file.
+type cachedHash struct {
+	Inode    uint64 `json:"inode"`
+	Size     int64  `json:"size"`
+	Modified int64  `json:"mtime"`
+	Changed  int64  `json:"ctime"`
+	Md5      string `json:"md5"`
+}
+
+// racyWindow is how old a modification must be for its hash to be cached, a
+// file written again within the same tick of the file system clock would
+// keep its times.
+const racyWindow = 2 * time.Second
+
+// DefaultHashCacheDir is $XDG_CACHE_HOME/capivara-sync, with ~/.cache when
+// XDG_CACHE_HOME is not set.
+func DefaultHashCacheDir() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(dir, "capivara-sync")
+}
+
+// WithHashCache makes a local source keep the index of its files in dir,
+// in a file named after the origin directory. The other sources hash their
+// files remotely and are returned unchanged.
+func WithHashCache(source Source, dir string, rehash bool) Source {
+	s, ok := source.(Localsource)
+	if !ok || dir == "" {
+		return source
+	}
+	origin, err := filepath.Abs(s.Localpath)
+	if err != nil {
+		origin = s.Localpath
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(origin)))
+	s.HashCache = &HashCache{Path: filepath.Join(dir, hex.EncodeToString(sum[:16])+".json"), Rehash: rehash}
+	return s
+}
+
+// hashIndex is the index of one listing, it holds the entries of the
+// previous listing and the ones of the files listed so far. A nil
+// hashIndex caches nothing.
+type hashIndex struct {
+	cache    *HashCache
+	previous map[string]cachedHash
+	current  map[string]cachedHash
+	started  time.Time
+}
+
+// open reads the index of the previous listing, a missing or unreadable one
+// is started over.
+func (c *HashCache) open() *hashIndex {
+	if c == nil {
+		return nil
+	}
+	index := &hashIndex{cache: c, previous: map[string]cachedHash{}, current: map[string]cachedHash{}, started: time.Now()}
+	if c.Rehash {
+		return index
+	}
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Error reading the hash cache, hashing every file: ", err)
+		}
+		return index
+	}
+	if err := json.Unmarshal(data, &index.previous); err != nil {
+		log.Warn("Error reading the hash cache ", c.Path, ", hashing every file: ", err)
+		index.previous = map[string]cachedHash{}
+	}
+	return index
+}
+
+// hash returns the cached hash of a file whose index entry did not change,
+// or the one hash computes, and records it for the next listing.
+func (x *hashIndex) hash(path string, info os.FileInfo, hash func() string) string {
+	if x == nil {
+		return hash()
+	}
+	_, inode, _, _ := fileIdentity(info)
+	entry := cachedHash{Inode: inode, Size: info.Size(), Modified: info.ModTime().UnixNano(), Changed: changeTime(info).UnixNano()}
+	if cached, ok := x.previous[path]; ok && cached.Md5 != "" {
+		md5 := cached.Md5
+		cached.Md5 = ""
+		if cached == entry {
+			log.Debug("Reusing the cached hash of ", path)
+			entry.Md5 = md5
+			x.current[path] = entry
+			return md5
+		}
+	}
+	entry.Md5 = hash()
+	if entry.Md5 != "" && x.started.Sub(info.ModTime()) >= racyWindow {
+		x.current[path] = entry
+	}
+	return entry.Md5
+}
+
+// save replaces the index with the entries of the files listed, the ones
+// of the files gone are dropped.
+func (x *hashIndex) save() {
+	if x == nil {
+		return
+	}
+	data, err := json.Marshal(x.current)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(x.cache.Path), 0700)
+	}
+	if err == nil {
+		tmp := x.cache.Path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, x.cache.Path)
+		}
+	}
+	if err != nil {
+		log.Warn("Error saving the hash cache: ", err)
+	}
+}
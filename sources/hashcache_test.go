@@ -0,0 +1,61 @@
+package sources
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listHashes(t *testing.T, source Source) map[string]string {
+	t.Helper()
+	hashes := map[string]string{}
+	for file := range source.ListFiles() {
+		hashes[filepath.ToSlash(file.Path)] = file.Md5
+	}
+	return hashes
+}
+
+func TestHashCache(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for name, content := range map[string]string{"a.txt": "alpha", "b.txt": "beta"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, name), old, old))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new.txt"), []byte("just written"), 0644))
+
+	source := WithHashCache(NewLocalsource(dir), cacheDir, false)
+	hashes := listHashes(t, source)
+	assert.Equal(t, "2c1743a391305fbf367df8e4f069f9f9", hashes["a.txt"])
+
+	cachePath := source.(Localsource).HashCache.Path
+	data, err := os.ReadFile(cachePath)
+	require.NoError(t, err)
+	cached := map[string]cachedHash{}
+	require.NoError(t, json.Unmarshal(data, &cached))
+	// a file modified right before the listing may change again unnoticed
+	assert.NotContains(t, cached, "new.txt")
+
+	// the cached hashes of the unchanged files are reused
+	entry := cached["a.txt"]
+	entry.Md5 = "cached"
+	cached["a.txt"] = entry
+	data, err = json.Marshal(cached)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cachePath, data, 0600))
+	assert.Equal(t, "cached", listHashes(t, source)["a.txt"])
+
+	// a changed file and a forced rehash read the files again
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a.txt"), old, old.Add(time.Minute)))
+	assert.Equal(t, "2c1743a391305fbf367df8e4f069f9f9", listHashes(t, source)["a.txt"])
+	require.NoError(t, os.WriteFile(cachePath, data, 0600))
+	rehash := WithHashCache(NewLocalsource(dir), cacheDir, true)
+	assert.Equal(t, "2c1743a391305fbf367df8e4f069f9f9", listHashes(t, rehash)["a.txt"])
+	assert.Equal(t, hashes["b.txt"], listHashes(t, source)["b.txt"])
+}
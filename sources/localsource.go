@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,6 +19,47 @@ import (
 
 type Localsource struct {
 	Localpath string
+	// Filter restricts the files ListFiles returns, nil lists everything.
+	Filter *Filter
+	// Xattrs chooses the extended attributes ListFiles reads, all of them by
+	// default.
+	Xattrs Xattrs
+	// HashCache keeps the hashes of the files between listings, nil hashes
+	// every file.
+	HashCache *HashCache
+}
+
+func init() {
+	Register("file", func(u *url.URL, options Options) (Source, error) {
+		if _, err := queryOptions(u); err != nil {
+			return nil, err
+		}
+		return NewLocalsource(localPath(u)), nil
+	})
+}
+
+// NewLocalsource serves the files under a local directory.
+func NewLocalsource(dir string) Localsource {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return Localsource{Localpath: dir}
+}
+
+// localPath is the path of a file URL, file:///srv/backups, file://./data
+// for a relative one and file:///C:/backups on windows.
+func localPath(u *url.URL) string {
+	p := u.Opaque
+	if p == "" {
+		p = u.Path
+		if u.Host != "" && u.Host != "localhost" {
+			p = u.Host + p
+		}
+	}
+	if len(p) > 2 && p[0] == '/' && filepath.VolumeName(filepath.FromSlash(p[1:])) != "" {
+		p = p[1:]
+	}
+	return p
 }
 
 func (l Localsource) Exists(path string) bool {
@@ -52,10 +94,77 @@ func (l Localsource) GetFile(path string) ([]byte, error) {
 	return os.ReadFile(l.Localpath + path)
 }
 
+func (l Localsource) OpenFile(path string) (io.ReadCloser, error) {
+	return os.Open(l.Localpath + path)
+}
+
+func (l Localsource) CreateFile(path string, permission string) (io.WriteCloser, error) {
+	filePath := l.Localpath + path
+
+	// Create directories if they don't exist
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directories: %v", err)
+	}
+
+	perm, err := FileModeFromString(permission)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse permission string: %v", err)
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %v", err)
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to chmod file: %v", err)
+	}
+	return file, nil
+}
+
 func (l Localsource) RemoveFile(path string) error {
 	return os.Remove(l.Localpath + path)
 }
 
+// SetMetadata sets the ownership first as chown clears the setuid and
+// setgid bits, then the mode, the extended attributes after it as the ACLs
+// hold the mode too, and the times.
+func (l Localsource) SetMetadata(path string, meta Metadata, numericOwner bool) error {
+	filePath := l.Localpath + path
+	root := os.Geteuid() == 0
+	if meta.Owner != nil && root {
+		uid, gid := ownerIds(*meta.Owner, numericOwner, localUserId, localGroupId)
+		if err := os.Lchown(filePath, uid, gid); err != nil {
+			return fmt.Errorf("failed to chown file: %w", err)
+		}
+	}
+	if meta.Mode != 0 && !meta.Symlink {
+		if err := os.Chmod(filePath, meta.Mode); err != nil {
+			return fmt.Errorf("failed to chmod file: %w", err)
+		}
+	}
+	if err := setXattrs(filePath, meta.Xattrs, root); err != nil {
+		return fmt.Errorf("failed to set extended attribute %w", err)
+	}
+	if !meta.Modified.IsZero() && !meta.Symlink {
+		accessed := meta.Accessed
+		if accessed.IsZero() {
+			accessed = meta.Modified
+		}
+		if err := os.Chtimes(filePath, accessed, meta.Modified); err != nil {
+			return fmt.Errorf("failed to set file times: %w", err)
+		}
+	}
+	return nil
+}
+
+func (l Localsource) RemoveDir(path string) error {
+	return os.Remove(l.Localpath + path)
+}
+
+func (l Localsource) RenameFile(oldpath string, newpath string) error {
+	return os.Rename(l.Localpath+oldpath, l.Localpath+newpath)
+}
+
 func (l Localsource) SaveFile(path string, data []byte, permission string) error {
 
 	filePath := l.Localpath + path
@@ -134,25 +243,127 @@ func (l Localsource) CalculateFileHash(filebyte []byte) (string, error) {
 	return remote_hash, nil
 }
 
+// ListNames lists the paths of the files, the filter is not applied.
+func (l Localsource) ListNames() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(l.Localpath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		names = append(names, strings.ReplaceAll(path, l.Localpath, ""))
+		return nil
+	})
+	return names, err
+}
+
 func (l Localsource) ListFiles() <-chan FileInfo {
+	return l.list(false)
+}
+
+// ListEntries lists the directories, links and special files with the files.
+func (l Localsource) ListEntries() <-chan FileInfo {
+	return l.list(true)
+}
+
+// list walks the directory, listing only the files like ListFiles or every
+// entry like ListEntries.
+func (l Localsource) list(entries bool) <-chan FileInfo {
 	ch := make(chan FileInfo)
 	go func() {
 		defer close(ch)
 
+		// inodes holds the path of the first file listed of every inode with
+		// hard links, and its hash
+		inodes := map[[2]uint64]FileInfo{}
+		hashes := l.HashCache.open()
+		defer hashes.save()
+		walk := l.Filter.Walk()
 		err := filepath.WalkDir(l.Localpath, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
+			if d.IsDir() {
+				relative_dir := strings.ReplaceAll(path, l.Localpath, "")
+				if relative_dir != "" && walk.SkipDir(relative_dir) {
+					log.Debug("Skipping excluded directory: ", path)
+					return filepath.SkipDir
+				}
+				if walk != nil {
+					if data, err := os.ReadFile(filepath.Join(path, IgnoreFileName)); err == nil {
+						walk.AddIgnoreFile(filepath.ToSlash(relative_dir), data)
+					}
+				}
+				if entries && relative_dir != "" {
+					info, err := d.Info()
+					if err != nil {
+						return err
+					}
+					owner, accessed := fileOwner(info)
+					ch <- FileInfo{Path: relative_dir, Filename: d.Name(), Permission: info.Mode().Perm().String(), LastModified: info.ModTime(),
+						Mode: info.Mode() & modeBits, Accessed: accessed, Owner: owner, Type: EntryDir, Xattrs: l.readXattrs(path)}
+				}
+			}
 			if !d.IsDir() {
 				relative_path := strings.ReplaceAll(path, l.Localpath, "")
-				md5sum, _ := l.GetFileHash(relative_path)
 				info, err := d.Info()
 				if err != nil {
 					log.Fatal(err)
 				}
 				modTime := info.ModTime()
-				log.Debug("File: ", path, " ", md5sum, " ", relative_path, " ", info.Mode().String())
-				ch <- FileInfo{Path: relative_path, Md5: md5sum, Filename: d.Name(), Permission: info.Mode().Perm().String(), LastModified: modTime}
+				// the size of a link or a special file is not a content size
+				var keep bool
+				if info.Mode().IsRegular() {
+					keep = walk.Keep(filepath.ToSlash(relative_path), info.Size(), modTime)
+				} else {
+					keep = walk.KeepEntry(filepath.ToSlash(relative_path), modTime)
+				}
+				if !keep {
+					log.Debug("Skipping excluded file: ", path)
+					return nil
+				}
+				owner, accessed := fileOwner(info)
+				file := FileInfo{Path: relative_path, Filename: d.Name(), Permission: info.Mode().Perm().String(), Size: info.Size(), LastModified: modTime,
+					Mode: info.Mode() & modeBits, Accessed: accessed, Owner: owner, Xattrs: l.readXattrs(path)}
+				var inode [2]uint64
+				if entries {
+					dev, ino, nlink, rdev := fileIdentity(info)
+					file.Type = entryType(info.Mode())
+					switch file.Type {
+					case "":
+						log.Warn("Skipping socket or unknown file type: ", path)
+						return nil
+					case EntrySymlink:
+						file.Size = 0
+						if file.LinkTarget, err = os.Readlink(path); err != nil {
+							return err
+						}
+						ch <- file
+						return nil
+					case EntryFifo, EntryDevice:
+						file.Size = 0
+						file.Mode = info.Mode() & (modeBits | os.ModeNamedPipe | os.ModeDevice | os.ModeCharDevice)
+						file.Device = rdev
+						ch <- file
+						return nil
+					}
+					if nlink > 1 {
+						inode = [2]uint64{dev, ino}
+						if first, ok := inodes[inode]; ok {
+							file.Type, file.LinkTarget, file.Md5 = EntryHardlink, first.Path, first.Md5
+							ch <- file
+							return nil
+						}
+					}
+				}
+				file.Md5 = hashes.hash(filepath.ToSlash(relative_path), info, func() string {
+					md5sum, _ := l.GetFileHash(relative_path)
+					return md5sum
+				})
+				if inode != [2]uint64{} {
+					inodes[inode] = file
+				}
+				log.Debug("File: ", path, " ", file.Md5, " ", relative_path, " ", info.Mode().String())
+				ch <- file
 			}
 			return nil
 		})
@@ -1,6 +1,7 @@
 package sources
 
 import (
+	"io"
 	"os"
 	"testing"
 	"time"
@@ -34,6 +35,22 @@ func TestSaveAndRemoveFile(t *testing.T) {
 	assert.False(t, ls.Exists("newfile.txt"))
 }
 
+func TestCreateAndOpenFile(t *testing.T) {
+	ls := Localsource{Localpath: t.TempDir() + "/"}
+	writer, err := ls.CreateFile("dir/streamed.txt", "-rw-------")
+	assert.NoError(t, err)
+	_, err = io.WriteString(writer, "streamed data")
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	reader, err := ls.OpenFile("dir/streamed.txt")
+	assert.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "streamed data", string(data))
+}
+
 func TestGetFileLastModified(t *testing.T) {
 	ls := Localsource{Localpath: "./testdata/"}
 	modTime, err := ls.GetFileLastModified("testfile.txt")
@@ -0,0 +1,140 @@
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Owner is the ownership of a file, the names are empty when unknown.
+type Owner struct {
+	Uid   int
+	Gid   int
+	User  string
+	Group string
+}
+
+// Metadata is what a restore gives a file back besides its content, the
+// zero values are left as CreateFile made them. Only the ownership and the
+// extended attributes of a Symlink are set, its mode and times would change
+// the file it points to.
+type Metadata struct {
+	Mode     os.FileMode
+	Modified time.Time
+	Accessed time.Time
+	Owner    *Owner
+	Symlink  bool
+	Xattrs   map[string][]byte
+}
+
+// MetadataSetter is a source that can set the metadata of its files. The
+// ownership is only set when running as root, to the ids of the owner names
+// on the source unless numericOwner asks for the recorded ids.
+type MetadataSetter interface {
+	SetMetadata(path string, meta Metadata, numericOwner bool) error
+}
+
+// modeBits are the bits of a file mode a backup records: the permission
+// and the setuid, setgid and sticky bits.
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// idTable maps the ids of a passwd or group file to their names and back.
+type idTable struct {
+	names map[int]string
+	ids   map[string]int
+}
+
+// parseIDTable reads the name:password:id:... lines of /etc/passwd or
+// /etc/group.
+func parseIDTable(data []byte) idTable {
+	table := idTable{names: map[int]string{}, ids: map[string]int{}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if _, ok := table.names[id]; !ok {
+			table.names[id] = fields[0]
+		}
+		table.ids[fields[0]] = id
+	}
+	return table
+}
+
+// ownerIds returns the ids a recorded owner has on a system, the names win
+// over the recorded ids unless numericOwner. lookup returns the id of a user
+// or group name.
+func ownerIds(owner Owner, numericOwner bool, lookupUser func(string) (int, bool), lookupGroup func(string) (int, bool)) (int, int) {
+	uid, gid := owner.Uid, owner.Gid
+	if numericOwner {
+		return uid, gid
+	}
+	if id, ok := lookupUser(owner.User); owner.User != "" && ok {
+		uid = id
+	}
+	if id, ok := lookupGroup(owner.Group); owner.Group != "" && ok {
+		gid = id
+	}
+	return uid, gid
+}
+
+// localNames caches the names of the local user and group ids.
+var localNames sync.Map
+
+func localUserName(uid int) string {
+	key := "u" + strconv.Itoa(uid)
+	if name, ok := localNames.Load(key); ok {
+		return name.(string)
+	}
+	name := ""
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		name = u.Username
+	}
+	localNames.Store(key, name)
+	return name
+}
+
+func localGroupName(gid int) string {
+	key := "g" + strconv.Itoa(gid)
+	if name, ok := localNames.Load(key); ok {
+		return name.(string)
+	}
+	name := ""
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		name = g.Name
+	}
+	localNames.Store(key, name)
+	return name
+}
+
+func localUserId(name string) (int, bool) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(u.Uid)
+	return id, err == nil
+}
+
+func localGroupId(name string) (int, bool) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(g.Gid)
+	return id, err == nil
+}
@@ -0,0 +1,25 @@
+package sources
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIDTable(t *testing.T) {
+	table := parseIDTable([]byte("# users\nroot:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/sh\n\nbroken:x\ntoor:x:0:0::/root:/bin/sh\n"))
+	assert.Equal(t, map[int]string{0: "root", 1000: "alice"}, table.names)
+	assert.Equal(t, map[string]int{"root": 0, "alice": 1000, "toor": 0}, table.ids)
+}
+
+func TestOwnerIds(t *testing.T) {
+	users := func(name string) (int, bool) { return map[string]int{"alice": 1001}[name], name == "alice" }
+	groups := func(name string) (int, bool) { return 0, false }
+
+	uid, gid := ownerIds(Owner{Uid: 1000, Gid: 100, User: "alice", Group: "staff"}, false, users, groups)
+	assert.Equal(t, []int{1001, 100}, []int{uid, gid}, "the names win, an unknown one keeps its id")
+	uid, gid = ownerIds(Owner{Uid: 1000, Gid: 100, User: "alice", Group: "staff"}, true, users, groups)
+	assert.Equal(t, []int{1000, 100}, []int{uid, gid})
+	uid, _ = ownerIds(Owner{Uid: 1000}, false, users, groups)
+	assert.Equal(t, 1000, uid)
+}
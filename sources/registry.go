@@ -0,0 +1,144 @@
+package sources
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Options are the credentials given next to a source URL, the ones in the
+// URL win.
+type Options struct {
+	User     string
+	Password string
+	// Prompt asks for what is missing, nil refuses to ask.
+	Prompt Prompt
+}
+
+// Constructor opens the source a URL points to, it reads the options of the
+// URL query it knows and refuses the others.
+type Constructor func(u *url.URL, options Options) (Source, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Constructor{}
+)
+
+// Register makes a backend available for the URLs of a scheme, backends
+// register themselves in init.
+func Register(scheme string, constructor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	scheme = strings.ToLower(scheme)
+	if _, ok := registry[scheme]; ok {
+		panic("sources: scheme " + scheme + " registered twice")
+	}
+	registry[scheme] = constructor
+}
+
+// Schemes lists the registered URL schemes.
+func Schemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	schemes := make([]string, 0, len(registry))
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+var (
+	schemePrefix = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+	// userPrefix is the user@http://host form of older versions.
+	userPrefix = regexp.MustCompile(`^([^@/:]+)@([a-zA-Z][a-zA-Z0-9+.-]*://.*)$`)
+)
+
+// Open returns the source of a spec: a URL of a registered scheme,
+// user@host:path for SFTP, or a local path.
+func Open(spec string, options Options) (Source, error) {
+	if match := userPrefix.FindStringSubmatch(spec); match != nil {
+		spec = match[2]
+		if options.User == "" {
+			options.User = match[1]
+		}
+	}
+
+	if !schemePrefix.MatchString(spec) {
+		if IsSSHTarget(spec) {
+			target, err := ParseSSHTarget(spec)
+			if err != nil {
+				return nil, err
+			}
+			return DialSSH(target, SSHOptions{User: options.User, Password: options.Password, Prompt: options.Prompt})
+		}
+		return NewLocalsource(spec), nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source URL: %w", err)
+	}
+	registryMu.RLock()
+	constructor, ok := registry[strings.ToLower(u.Scheme)]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown source scheme %q, use %s", u.Scheme, strings.Join(Schemes(), ", "))
+	}
+
+	if u.User != nil {
+		options.User = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			options.Password = password
+		}
+	}
+	return constructor(u, options)
+}
+
+// Location is how snapshots record the source of a spec: local paths are
+// made absolute and the passwords of URLs are left out.
+func Location(spec string) string {
+	if match := userPrefix.FindStringSubmatch(spec); match != nil {
+		spec = match[2]
+	}
+	if schemePrefix.MatchString(spec) {
+		u, err := url.Parse(spec)
+		if err != nil {
+			return spec
+		}
+		if u.User != nil {
+			u.User = url.User(u.User.Username())
+		}
+		return u.String()
+	}
+	if IsSSHTarget(spec) {
+		return spec
+	}
+	if abs, err := filepath.Abs(spec); err == nil {
+		return abs
+	}
+	return spec
+}
+
+// queryOptions returns the query of a source URL, refusing the options the
+// backend does not know.
+func queryOptions(u *url.URL, known ...string) (url.Values, error) {
+	query := u.Query()
+	for name := range query {
+		found := false
+		for _, k := range known {
+			found = found || name == k
+		}
+		if !found {
+			if len(known) == 0 {
+				return nil, fmt.Errorf("%s URLs take no options, got %q", u.Scheme, name)
+			}
+			return nil, fmt.Errorf("unknown %s option %q, use %s", u.Scheme, name, strings.Join(known, ", "))
+		}
+	}
+	return query, nil
+}
@@ -0,0 +1,630 @@
+package sources
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultS3PartSize is the size of the parts of multipart uploads.
+	DefaultS3PartSize = 16 << 20
+	// MinS3PartSize is the smallest part S3 accepts, but for the last one.
+	MinS3PartSize = 5 << 20
+)
+
+// S3Source keeps the files as objects of an S3 bucket, under Prefix. It
+// works with AWS and with S3 compatible services like MinIO.
+type S3Source struct {
+	// Endpoint is the URL of the service, like http://localhost:9000.
+	Endpoint string
+	Bucket   string
+	// Prefix is prepended to every key, it is empty or ends with a slash.
+	Prefix       string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PathStyle puts the bucket in the path instead of the host name, as
+	// MinIO and most compatible services expect.
+	PathStyle bool
+	// PartSize is the size of the parts of multipart uploads, smaller files
+	// are uploaded with a single request.
+	PartSize int64
+	// Filter restricts the files ListFiles returns, nil lists everything.
+	Filter *Filter
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+func NewS3Source(endpoint, bucket, prefix, accessKey, secretKey string) (*S3Source, error) {
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if bucket == "" {
+		return nil, errors.New("S3 bucket not provided")
+	}
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Source{
+		Endpoint:  endpoint,
+		Bucket:    bucket,
+		Prefix:    prefix,
+		Region:    "us-east-1",
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PathStyle: true,
+		PartSize:  DefaultS3PartSize,
+	}, nil
+}
+
+func init() {
+	Register("s3", openS3)
+}
+
+// openS3 opens s3://bucket/prefix URLs. The endpoint option points to a
+// compatible service, AWS is used without it. The credentials are the user
+// and password of the source or $AWS_ACCESS_KEY_ID and
+// $AWS_SECRET_ACCESS_KEY.
+func openS3(u *url.URL, options Options) (Source, error) {
+	query, err := queryOptions(u, "endpoint", "region", "path_style", "part_size", "timeout", "insecure")
+	if err != nil {
+		return nil, err
+	}
+
+	region := firstNonEmpty(query.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	endpoint := query.Get("endpoint")
+	pathStyle := endpoint != ""
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	} else if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	if value := query.Get("path_style"); value != "" {
+		if pathStyle, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid s3 path_style %q: %w", value, err)
+		}
+	}
+
+	accessKey, secretKey := options.User, options.Password
+	if accessKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("S3 credentials not provided, set the user and password or $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	}
+
+	source, err := NewS3Source(endpoint, u.Host, u.Path, accessKey, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	source.Region = region
+	source.PathStyle = pathStyle
+	if options.User == "" {
+		source.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if value := query.Get("part_size"); value != "" {
+		if source.PartSize, err = ParseSize(value); err != nil {
+			return nil, err
+		}
+		if source.PartSize < MinS3PartSize {
+			return nil, fmt.Errorf("s3 part_size must be at least 5M, got %s", value)
+		}
+	}
+
+	client := &http.Client{}
+	if timeout := query.Get("timeout"); timeout != "" {
+		if client.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid s3 timeout %q: %w", timeout, err)
+		}
+	}
+	if insecure := query.Get("insecure"); insecure != "" {
+		skip, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3 insecure %q: %w", insecure, err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: skip}
+		client.Transport = transport
+	}
+	source.Client = client
+	return source, nil
+}
+
+func (s *S3Source) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+	return s.Client
+}
+
+// objectURL is the URL of an object, or of the bucket for an empty key.
+func (s *S3Source) objectURL(key string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	p := "/" + key
+	if s.PathStyle {
+		p = "/" + s.Bucket
+		if key != "" {
+			p += "/" + key
+		}
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3CanonicalQuery(query)
+	return u, nil
+}
+
+// do sends a signed request for an object, key "" is the bucket.
+func (s *S3Source) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := s.objectURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, hexSHA256(body), time.Now())
+	return s.client().Do(req)
+}
+
+// s3Error is the error document S3 answers failed requests with.
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// responseError describes a failed request, with the error document when
+// the response has one.
+func responseError(method string, key string, resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var doc s3Error
+	err := fmt.Errorf("S3 %s %s: %s", method, key, resp.Status)
+	if xml.Unmarshal(data, &doc) == nil && doc.Code != "" {
+		err = fmt.Errorf("S3 %s %s: %s: %s", method, key, doc.Code, doc.Message)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w (%w)", err, fs.ErrNotExist)
+	}
+	return err
+}
+
+func (s *S3Source) CalculateFileHash(filebyte []byte) (string, error) {
+	sum := md5.Sum(filebyte)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// list returns the objects under the prefix, following the continuation
+// tokens of ListObjectsV2.
+func (s *S3Source) list() ([]FileInfo, error) {
+	var files []FileInfo
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if s.Prefix != "" {
+			query.Set("prefix", s.Prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := responseError("list", s.Prefix, resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse S3 listing: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			if strings.HasSuffix(object.Key, "/") {
+				continue
+			}
+			remote_path := strings.TrimPrefix(object.Key, s.Prefix)
+			files = append(files, FileInfo{
+				Path:         remote_path,
+				Md5:          etagMD5(object.ETag),
+				Filename:     path.Base(remote_path),
+				Permission:   "-rw-r--r--",
+				Size:         object.Size,
+				RemoteHash:   strings.Trim(object.ETag, `"`),
+				LastModified: object.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// ListNames lists the keys of the objects under the prefix, the filter is
+// not applied.
+func (s *S3Source) ListNames() ([]string, error) {
+	files, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Path)
+	}
+	return names, nil
+}
+
+func (s *S3Source) ListFiles() <-chan FileInfo {
+	ch := make(chan FileInfo)
+	go func() {
+		defer close(ch)
+		files, err := s.list()
+		if err != nil {
+			log.Error("Error listing S3 objects: ", err)
+			return
+		}
+
+		// the listing is flat, the ignore files are loaded before any file
+		// they may exclude
+		walk := s.Filter.Walk()
+		if walk != nil {
+			for _, file := range files {
+				if file.Filename != IgnoreFileName {
+					continue
+				}
+				if data, err := s.GetFile(file.Path); err == nil {
+					walk.AddIgnoreFile(path.Dir(file.Path), data)
+				}
+			}
+		}
+
+		for _, file := range files {
+			if !walk.Keep(file.Path, file.Size, file.LastModified) {
+				log.Debug("Skipping excluded file: ", file.Path)
+				continue
+			}
+			if file.Md5 == "" {
+				md5, err := s.GetFileHash(file.Path)
+				if err != nil {
+					log.Error("Error getting file hash:", err)
+				}
+				file.Md5 = md5
+			}
+			ch <- file
+		}
+	}()
+	return ch
+}
+
+// etagMD5 returns the MD5 an ETag holds, the ETags of multipart uploads are
+// not the MD5 of the content and give "".
+func etagMD5(etag string) string {
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != 32 || strings.Contains(etag, "-") {
+		return ""
+	}
+	return strings.ToLower(etag)
+}
+
+func (s *S3Source) head(path string) (*http.Response, error) {
+	resp, err := s.do(http.MethodHead, s.Prefix+path, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("S3 HEAD %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *S3Source) Exists(path string) bool {
+	_, err := s.head(path)
+	return err == nil
+}
+
+// GetFileHash returns the MD5 of an object from its ETag, the objects
+// uploaded in parts are downloaded to hash them.
+func (s *S3Source) GetFileHash(path string) (string, error) {
+	resp, err := s.head(path)
+	if err != nil {
+		return "", err
+	}
+	if md5 := etagMD5(resp.Header.Get("ETag")); md5 != "" {
+		return md5, nil
+	}
+
+	reader, err := s.OpenFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *S3Source) GetFileLastModified(remote_path string) (time.Time, error) {
+	resp, err := s.head(remote_path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return http.ParseTime(resp.Header.Get("Last-Modified"))
+}
+
+func (s *S3Source) OpenFile(path string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s.Prefix+path, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError("GET", path, resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Source) GetFile(path string) ([]byte, error) {
+	reader, err := s.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// contentMD5 is the Content-MD5 header S3 checks the uploaded data against.
+func contentMD5(data []byte) http.Header {
+	sum := md5.Sum(data)
+	return http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+}
+
+// put uploads an object with a single request, S3 checks it against its MD5.
+func (s *S3Source) put(key string, data []byte) error {
+	resp, err := s.do(http.MethodPut, key, nil, contentMD5(data), data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("PUT", key, resp)
+	}
+	return nil
+}
+
+// SaveFile uploads the data, S3 has no permissions so permission is unused.
+func (s *S3Source) SaveFile(path string, data []byte, permission string) error {
+	return s.put(s.Prefix+path, data)
+}
+
+// CreateFile buffers up to PartSize bytes, a larger file is sent as a
+// multipart upload the object appears from only once Close completes it.
+func (s *S3Source) CreateFile(path string, permission string) (io.WriteCloser, error) {
+	partSize := s.PartSize
+	if partSize < MinS3PartSize {
+		partSize = MinS3PartSize
+	}
+	return &s3Writer{source: s, key: s.Prefix + path, partSize: int(partSize)}, nil
+}
+
+func (s *S3Source) RemoveFile(path string) error {
+	resp, err := s.do(http.MethodDelete, s.Prefix+path, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return responseError("DELETE", path, resp)
+	}
+	return nil
+}
+
+// RemoveDir does nothing, S3 has no directories but the prefixes of its keys.
+func (s *S3Source) RemoveDir(path string) error {
+	return nil
+}
+
+// RenameFile copies the object to its new key and removes the old one, S3
+// cannot move objects.
+func (s *S3Source) RenameFile(oldpath string, newpath string) error {
+	header := http.Header{"X-Amz-Copy-Source": {s3Escape("/"+s.Bucket+"/"+s.Prefix+oldpath, false)}}
+	resp, err := s.do(http.MethodPut, s.Prefix+newpath, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("COPY", oldpath, resp)
+	}
+	// a copy can fail after the 200 status was sent, the body tells
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var doc s3Error
+	if xml.Unmarshal(data, &doc) == nil {
+		return fmt.Errorf("S3 COPY %s: %s: %s", oldpath, doc.Code, doc.Message)
+	}
+	return s.RemoveFile(oldpath)
+}
+
+// s3Writer uploads the data written in parts of partSize bytes, the data is
+// sent with a single request when it fits in one part.
+type s3Writer struct {
+	source   *S3Source
+	key      string
+	partSize int
+	buffer   []byte
+	uploadID string
+	parts    []s3Part
+	err      error
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buffer = append(w.buffer, p...)
+	// the last part may be smaller, keep some data for it
+	for len(w.buffer) > w.partSize {
+		if w.err = w.uploadPart(w.buffer[:w.partSize]); w.err != nil {
+			w.abort()
+			return 0, w.err
+		}
+		w.buffer = append([]byte(nil), w.buffer[w.partSize:]...)
+	}
+	return len(p), nil
+}
+
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errors.New("file already closed")
+	if w.uploadID == "" {
+		return w.source.put(w.key, w.buffer)
+	}
+	if err := w.uploadPart(w.buffer); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.complete(); err != nil {
+		w.abort()
+		return err
+	}
+	return nil
+}
+
+func (w *s3Writer) uploadPart(data []byte) error {
+	if w.uploadID == "" {
+		if err := w.initiate(); err != nil {
+			return err
+		}
+	}
+	number := len(w.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {w.uploadID}}
+	resp, err := w.source.do(http.MethodPut, w.key, query, contentMD5(data), data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("PUT part", w.key, resp)
+	}
+	w.parts = append(w.parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+
+func (w *s3Writer) initiate() error {
+	resp, err := w.source.do(http.MethodPost, w.key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("POST uploads", w.key, resp)
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to parse S3 multipart upload: %w", err)
+	}
+	w.uploadID = result.UploadID
+	return nil
+}
+
+func (w *s3Writer) complete() error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	resp, err := w.source.do(http.MethodPost, w.key, url.Values{"uploadId": {w.uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("POST complete", w.key, resp)
+	}
+	// S3 reports errors of the completion in a 200 response
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var doc s3Error
+	if xml.Unmarshal(data, &doc) == nil {
+		return fmt.Errorf("S3 POST complete %s: %s: %s", w.key, doc.Code, doc.Message)
+	}
+	return nil
+}
+
+// abort drops the parts uploaded so far, S3 keeps them otherwise.
+func (w *s3Writer) abort() {
+	if w.uploadID == "" {
+		return
+	}
+	resp, err := w.source.do(http.MethodDelete, w.key, url.Values{"uploadId": {w.uploadID}}, nil, nil)
+	if err != nil {
+		log.Warn("Error aborting multipart upload of ", w.key, ": ", err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -1,6 +1,42 @@
 package sources
 
 type Setting struct {
+	// Compress stores the blocks compressed with Codec, a compressor codec
+	// spec like "zstd:19".
 	Compress  bool
+	Codec     string
 	Skip_hash bool
+	// Encrypt creates an encrypted repository on a new destination, existing
+	// encrypted repositories are always opened with Password.
+	Encrypt  bool
+	Password string
+	// Jobs is the number of files transferred at the same time.
+	Jobs int
+	// DryRun only reports what would change, nothing is written.
+	DryRun bool
+	// Origin, Tags and Description describe the snapshot a backup takes,
+	// Origin is the path or URL of the backed up source.
+	Origin      string
+	Tags        []string
+	Description string
+	// Resume continues the snapshot of an interrupted backup.
+	Resume bool
+	// RestorePath and Include select the files a restore writes: the ones
+	// under RestorePath matching one of the Include glob patterns. The
+	// StripPrefix directory is removed from their path, the files outside it
+	// are not restored.
+	RestorePath string
+	Include     []string
+	StripPrefix string
+	// Trash is the directory of the restore target a clean moves the files
+	// it removes to, they are deleted when it is empty. A clean removing more
+	// than CleanLimit files asks Prompt first, 0 never asks.
+	Trash      string
+	CleanLimit int
+	Prompt     Prompt
+	// NumericOwner restores the recorded owner ids instead of looking up
+	// the owner names on the restore target.
+	NumericOwner bool
+	// NoXattrs leaves the extended attributes out of a restore.
+	NoXattrs bool
 }
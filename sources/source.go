@@ -1,23 +1,58 @@
 package sources
 
-import "time"
+import (
+	"io"
+	"os"
+	"time"
+)
 
 type Source interface {
 	ListFiles() <-chan FileInfo
 	GetFile(string) ([]byte, error)
 	SaveFile(string, []byte, string) error
+	// OpenFile and CreateFile stream a file without holding it in memory,
+	// the file written by CreateFile is complete only once Close returns nil.
+	OpenFile(string) (io.ReadCloser, error)
+	CreateFile(string, string) (io.WriteCloser, error)
 	Exists(string) bool
 	GetFileHash(string) (string, error)
 	RemoveFile(string) error
+	// RemoveDir removes an empty directory, it fails on a directory that
+	// still holds files.
+	RemoveDir(string) error
+	// RenameFile moves a file replacing the target, it is used to publish
+	// files atomically once completely written.
+	RenameFile(string, string) error
 	CalculateFileHash([]byte) (string, error)
 	GetFileLastModified(remote_path string) (time.Time, error)
 }
 
+// NameLister is a source that can list the paths of its files without
+// reading them, ListFiles hashes every file on the sources where it is not
+// stored.
+type NameLister interface {
+	ListNames() ([]string, error)
+}
+
 type FileInfo struct {
 	Path         string
 	Md5          string
 	Filename     string
 	Permission   string
+	Size         int64
 	RemoteHash   string
 	LastModified time.Time
+	// Mode is the full mode with the setuid, setgid and sticky bits, 0 when
+	// the source has none. Accessed and Owner are zero or nil likewise.
+	Mode     os.FileMode
+	Accessed time.Time
+	Owner    *Owner
+	// Type is the entry type, "" or EntryFile for a regular file. LinkTarget
+	// is the target of a symbolic link or the path of the file a hard link
+	// shares its inode with, Device the device number of a device file.
+	Type       string
+	LinkTarget string
+	Device     uint64
+	// Xattrs are the extended attributes by name, nil when none are read.
+	Xattrs map[string][]byte
 }
@@ -3,13 +3,16 @@ package sources
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/pkg/sftp"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 	"io"
+	"io/fs"
 	"path"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,16 +20,35 @@ type SSHSource struct {
 	Client   *ssh.Client
 	SFTP     *sftp.Client
 	BasePath string
+	// Filter restricts the files ListFiles returns, nil lists everything.
+	Filter *Filter
+
+	// users and groups are the id tables of the server, read once
+	idsOnce sync.Once
+	users   idTable
+	groups  idTable
+	// agent is the connection to the ssh-agent signing for the client
+	agent io.Closer
 }
 
-func NewSSHSource(user, addr, basePath string, authMethod ssh.AuthMethod) (*SSHSource, error) {
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{authMethod},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // For production, replace with proper callback
-		Timeout:         10 * time.Second,
+// Close closes the SFTP session, the connection and the one to the agent.
+func (s *SSHSource) Close() error {
+	var errs []error
+	if s.SFTP != nil {
+		errs = append(errs, s.SFTP.Close())
+	}
+	if s.Client != nil {
+		errs = append(errs, s.Client.Close())
+	}
+	if s.agent != nil {
+		errs = append(errs, s.agent.Close())
 	}
+	return errors.Join(errs...)
+}
 
+// NewSSHSource connects to addr and serves the files under basePath over
+// SFTP, DialSSH builds the config from the user's ssh setup.
+func NewSSHSource(addr, basePath string, config *ssh.ClientConfig) (*SSHSource, error) {
 	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
 		return nil, fmt.Errorf("SSH connection failed: %w", err)
@@ -75,24 +97,70 @@ func (s *SSHSource) ListFiles() <-chan FileInfo {
 	ch := make(chan FileInfo)
 	go func() {
 		defer close(ch)
+		walk := s.Filter.Walk()
 		walker := s.SFTP.Walk(s.BasePath)
 		for walker.Step() {
 			if err := walker.Err(); err != nil {
 				continue
 			}
 			stat := walker.Stat()
+			relative_path := strings.TrimPrefix(walker.Path(), s.BasePath)
+			if stat.IsDir() {
+				if relative_path != "" && walk.SkipDir(relative_path) {
+					log.Debug("Skipping excluded directory: ", walker.Path())
+					walker.SkipDir()
+				} else if walk != nil {
+					if data, err := s.GetFile(path.Join(relative_path, IgnoreFileName)); err == nil {
+						walk.AddIgnoreFile(relative_path, data)
+					}
+				}
+				continue
+			}
+			if !walk.Keep(relative_path, stat.Size(), stat.ModTime()) {
+				log.Debug("Skipping excluded file: ", walker.Path())
+				continue
+			}
+			md5sum, err := s.GetFileHash(relative_path)
+			if err != nil {
+				log.Error("Error getting file hash:", err)
+			}
 			// Get last modification time
 			modTime := stat.ModTime()
-			ch <- FileInfo{
-				Path:         walker.Path(),
+			info := FileInfo{
+				Path:         relative_path,
+				Md5:          md5sum,
+				Filename:     stat.Name(),
 				Permission:   stat.Mode().Perm().String(),
+				Size:         stat.Size(),
 				LastModified: modTime,
+				Mode:         stat.Mode() & modeBits,
+			}
+			if fs, ok := stat.Sys().(*sftp.FileStat); ok {
+				users, groups := s.idTables()
+				info.Accessed = time.Unix(int64(fs.Atime), 0)
+				info.Owner = &Owner{Uid: int(fs.UID), Gid: int(fs.GID), User: users.names[int(fs.UID)], Group: groups.names[int(fs.GID)]}
 			}
+			ch <- info
 		}
 	}()
 	return ch
 }
 
+// ListNames lists the paths of the files, the filter is not applied.
+func (s *SSHSource) ListNames() ([]string, error) {
+	var names []string
+	walker := s.SFTP.Walk(s.BasePath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+		if !walker.Stat().IsDir() {
+			names = append(names, strings.TrimPrefix(walker.Path(), s.BasePath))
+		}
+	}
+	return names, nil
+}
+
 func (s *SSHSource) GetFile(path string) ([]byte, error) {
 	f, err := s.SFTP.Open(s.BasePath + path)
 	if err != nil {
@@ -103,18 +171,60 @@ func (s *SSHSource) GetFile(path string) ([]byte, error) {
 	return io.ReadAll(f)
 }
 
-func ensureRemoteDir(sftpClient *sftp.Client, remotePath string) error {
-	dirs := strings.Split(path.Clean(path.Dir(remotePath)), "/")
-	curr := "/"
-	for _, dir := range dirs {
-		if dir == "" {
+func (s *SSHSource) OpenFile(path string) (io.ReadCloser, error) {
+	return s.SFTP.Open(s.BasePath + path)
+}
+
+func (s *SSHSource) CreateFile(path string, perm string) (io.WriteCloser, error) {
+	filePath := s.BasePath + path
+
+	if err := ensureRemoteDir(s.SFTP, filePath); err != nil {
+		return nil, fmt.Errorf("failed to create remote dirs: %w", err)
+	}
+
+	permission, err := FileModeFromString(perm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse permission string: %w", err)
+	}
+	f, err := s.SFTP.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(permission); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to chmod remote file: %w", err)
+	}
+	return f, nil
+}
+
+// remoteDirs returns the directories holding a remote path from the top, a
+// relative path stays relative to the home directory the server starts in.
+func remoteDirs(remotePath string) []string {
+	dir := path.Clean(path.Dir(remotePath))
+	curr := ""
+	if strings.HasPrefix(dir, "/") {
+		curr = "/"
+	}
+	var dirs []string
+	for _, name := range strings.Split(dir, "/") {
+		if name == "" || name == "." {
 			continue
 		}
-		curr = path.Join(curr, dir)
+		curr = path.Join(curr, name)
+		dirs = append(dirs, curr)
+	}
+	return dirs
+}
+
+func ensureRemoteDir(sftpClient *sftp.Client, remotePath string) error {
+	for _, curr := range remoteDirs(remotePath) {
 		_, err := sftpClient.Stat(curr)
 		if err != nil {
 			if err := sftpClient.Mkdir(curr); err != nil {
-				return err
+				// another worker may have created it meanwhile
+				if _, statErr := sftpClient.Stat(curr); statErr != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -160,6 +270,105 @@ func (s *SSHSource) RemoveFile(path string) error {
 	return s.SFTP.Remove(s.BasePath + path)
 }
 
+// idTables reads the users and groups of the server, they stay empty when
+// it has no /etc/passwd or /etc/group.
+func (s *SSHSource) idTables() (idTable, idTable) {
+	s.idsOnce.Do(func() {
+		read := func(name string) idTable {
+			f, err := s.SFTP.Open(name)
+			if err != nil {
+				log.Debug("Cannot read ", name, " of the server: ", err)
+				return parseIDTable(nil)
+			}
+			defer f.Close()
+			data, _ := io.ReadAll(f)
+			return parseIDTable(data)
+		}
+		s.users, s.groups = read("/etc/passwd"), read("/etc/group")
+	})
+	return s.users, s.groups
+}
+
+// SetMetadata sets the ownership when logged in as root, then the mode and
+// the times of a remote file.
+func (s *SSHSource) SetMetadata(path string, meta Metadata, numericOwner bool) error {
+	filePath := s.BasePath + path
+	if meta.Symlink {
+		// SFTP has no lchown, it would change the file the link points to
+		return nil
+	}
+	if meta.Owner != nil && s.Client != nil && s.Client.User() == "root" {
+		users, groups := s.idTables()
+		lookup := func(table idTable) func(string) (int, bool) {
+			return func(name string) (int, bool) {
+				id, ok := table.ids[name]
+				return id, ok
+			}
+		}
+		uid, gid := ownerIds(*meta.Owner, numericOwner, lookup(users), lookup(groups))
+		if err := s.SFTP.Chown(filePath, uid, gid); err != nil {
+			return fmt.Errorf("failed to chown remote file: %w", err)
+		}
+	}
+	if meta.Mode != 0 {
+		if err := s.SFTP.Chmod(filePath, meta.Mode); err != nil {
+			return fmt.Errorf("failed to chmod remote file: %w", err)
+		}
+	}
+	if !meta.Modified.IsZero() {
+		accessed := meta.Accessed
+		if accessed.IsZero() {
+			accessed = meta.Modified
+		}
+		if err := s.SFTP.Chtimes(filePath, accessed, meta.Modified); err != nil {
+			return fmt.Errorf("failed to set remote file times: %w", err)
+		}
+	}
+	return nil
+}
+
+// CreateEntry makes a directory or a link, SFTP cannot create fifos and
+// devices.
+func (s *SSHSource) CreateEntry(entry FileInfo) error {
+	filePath := s.BasePath + entry.Path
+	if entry.Type == EntryDir {
+		return ensureRemoteDir(s.SFTP, filePath+"/")
+	}
+	if err := ensureRemoteDir(s.SFTP, filePath); err != nil {
+		return err
+	}
+	if existing, err := s.SFTP.Lstat(filePath); err == nil {
+		if existing.IsDir() {
+			return fmt.Errorf("%s is a directory", entry.Path)
+		}
+		if err := s.SFTP.Remove(filePath); err != nil {
+			return err
+		}
+	}
+	switch entry.Type {
+	case EntrySymlink:
+		return s.SFTP.Symlink(entry.LinkTarget, filePath)
+	case EntryHardlink:
+		return s.SFTP.Link(s.BasePath+entry.LinkTarget, filePath)
+	}
+	return fmt.Errorf("cannot create %s of type %q over SFTP", entry.Path, entry.Type)
+}
+
+func (s *SSHSource) RemoveDir(path string) error {
+	return s.SFTP.RemoveDirectory(s.BasePath + path)
+}
+
+func (s *SSHSource) RenameFile(oldpath string, newpath string) error {
+	if err := s.SFTP.PosixRename(s.BasePath+oldpath, s.BasePath+newpath); err == nil {
+		return nil
+	}
+	// servers without the posix-rename extension refuse to replace the target
+	if err := s.SFTP.Remove(s.BasePath + newpath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return s.SFTP.Rename(s.BasePath+oldpath, s.BasePath+newpath)
+}
+
 func (s *SSHSource) CalculateFileHash(filebyte []byte) (string, error) {
 	hash := md5.Sum(filebyte) // returns [16]byte
 	remote_hash := hex.EncodeToString(hash[:])
@@ -0,0 +1,15 @@
+package sources
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteDirs(t *testing.T) {
+	assert.Equal(t, []string{"/srv", "/srv/backups"}, remoteDirs("/srv/backups/block_1.zst"))
+	// a relative path is created under the home directory
+	assert.Equal(t, []string{"backups", "backups/x"}, remoteDirs("backups/x/block_1.zst"))
+	assert.Empty(t, remoteDirs("block_1.zst"))
+	assert.Empty(t, remoteDirs("/block_1.zst"))
+}
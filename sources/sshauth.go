@@ -0,0 +1,354 @@
+package sources
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Prompt asks the user a question, the answer is not echoed when secret.
+type Prompt func(question string, secret bool) (string, error)
+
+// ErrNoPrompt is returned by the prompts when there is no one to ask.
+var ErrNoPrompt = errors.New("cannot prompt, no terminal")
+
+// SSHOptions tell DialSSH how to authenticate.
+type SSHOptions struct {
+	// User and Password override the target and the ssh config, the
+	// password is prompted for when the server asks for one.
+	User     string
+	Password string
+	// Prompt asks for key passphrases, passwords and whether to trust an
+	// unknown host, nil refuses all of them.
+	Prompt Prompt
+	// ConfigFile and KnownHostsFile default to ~/.ssh/config and
+	// ~/.ssh/known_hosts.
+	ConfigFile     string
+	KnownHostsFile string
+	// IdentityFiles replace the ones of the ssh config.
+	IdentityFiles []string
+}
+
+func init() {
+	Register("sftp", openSFTP)
+	Register("ssh", openSFTP)
+}
+
+// openSFTP opens sftp://user@host:port/path URLs. The config, known_hosts
+// and identity options replace ~/.ssh/config, ~/.ssh/known_hosts and the
+// identity files, identity can be given several times.
+func openSFTP(u *url.URL, options Options) (Source, error) {
+	query, err := queryOptions(u, "config", "known_hosts", "identity")
+	if err != nil {
+		return nil, err
+	}
+	target := SSHTarget{User: options.User, Host: u.Hostname(), Path: u.Path}
+	if port := u.Port(); port != "" {
+		if target.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid port in %s", u.Redacted())
+		}
+	}
+	if target.Host == "" {
+		return nil, fmt.Errorf("missing host in %s", u.Redacted())
+	}
+	if target.Path == "" {
+		target.Path = "/"
+	}
+
+	sshOptions := SSHOptions{
+		Password:       options.Password,
+		Prompt:         options.Prompt,
+		ConfigFile:     expandSSHPath(query.Get("config"), homeDir(), target.Host, target.User),
+		KnownHostsFile: expandSSHPath(query.Get("known_hosts"), homeDir(), target.Host, target.User),
+		IdentityFiles:  query["identity"],
+	}
+	return DialSSH(target, sshOptions)
+}
+
+func homeDir() string {
+	home, _ := os.UserHomeDir()
+	return home
+}
+
+// DialSSH connects to an SSH target, resolving host aliases with the ssh
+// config. The keys of the agent at SSH_AUTH_SOCK and the identity files are
+// tried before the password.
+func DialSSH(target SSHTarget, options SSHOptions) (*SSHSource, error) {
+	agentConn := dialAgent()
+	config, addr, err := options.clientConfig(target, agentConn)
+	if err == nil {
+		basePath := target.Path
+		if !strings.HasSuffix(basePath, "/") {
+			basePath += "/"
+		}
+		var source *SSHSource
+		if source, err = NewSSHSource(addr, basePath, config); err == nil {
+			// the agent signs for the connection until it is closed
+			source.agent = agentConn
+			return source, nil
+		}
+	}
+	if agentConn != nil {
+		agentConn.Close()
+	}
+	return nil, err
+}
+
+// dialAgent connects to the agent at SSH_AUTH_SOCK, nil when there is none.
+func dialAgent() net.Conn {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		log.Warn("Error connecting to ssh-agent: ", err)
+		return nil
+	}
+	return conn
+}
+
+// clientConfig builds the client configuration and the address to dial,
+// agentConn is the connection to the agent, nil when there is none.
+func (o SSHOptions) clientConfig(target SSHTarget, agentConn net.Conn) (*ssh.ClientConfig, string, error) {
+	home := homeDir()
+	configFile := o.ConfigFile
+	if configFile == "" {
+		configFile = filepath.Join(home, ".ssh", "config")
+	}
+	hostConfig, err := ReadSSHConfig(configFile, target.Host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	host := target.Host
+	if hostConfig.HostName != "" {
+		host = expandSSHPath(hostConfig.HostName, home, target.Host, "")
+	}
+	user := firstNonEmpty(o.User, target.User, hostConfig.User, os.Getenv("USER"))
+	port := 22
+	if target.Port != 0 {
+		port = target.Port
+	} else if hostConfig.Port != 0 {
+		port = hostConfig.Port
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	identities := hostConfig.IdentityFiles
+	if len(o.IdentityFiles) > 0 {
+		identities = o.IdentityFiles
+	}
+	identities = append([]string(nil), identities...)
+	if len(identities) == 0 {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			identities = append(identities, filepath.Join(home, ".ssh", name))
+		}
+	}
+	for i, identity := range identities {
+		identities[i] = expandSSHPath(identity, home, target.Host, user)
+	}
+
+	knownHostsFile := o.KnownHostsFile
+	if knownHostsFile == "" && hostConfig.KnownHostsFile != "" {
+		knownHostsFile = expandSSHPath(hostConfig.KnownHostsFile, home, target.Host, user)
+	}
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, algorithms, err := o.hostKeyCallback(knownHostsFile, addr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeysCallback(o.signers(agentConn, identities)),
+			ssh.PasswordCallback(func() (string, error) {
+				if o.Password != "" {
+					return o.Password, nil
+				}
+				return o.ask(fmt.Sprintf("%s@%s's password: ", user, host), true)
+			}),
+		},
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: algorithms,
+		Timeout:           10 * time.Second,
+	}
+	return config, addr, nil
+}
+
+func (o SSHOptions) ask(question string, secret bool) (string, error) {
+	if o.Prompt == nil {
+		return "", ErrNoPrompt
+	}
+	return o.Prompt(question, secret)
+}
+
+// signers returns the keys of the agent and of the identity files. The
+// passphrase of an encrypted identity is only asked for when the agent has
+// no keys, the agent usually holds the decrypted one.
+func (o SSHOptions) signers(agentConn net.Conn, identities []string) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		if agentConn != nil {
+			agentSigners, err := agent.NewClient(agentConn).Signers()
+			if err != nil {
+				log.Warn("Error listing ssh-agent keys: ", err)
+			}
+			signers = append(signers, agentSigners...)
+		}
+
+		for _, identity := range identities {
+			data, err := os.ReadFile(identity)
+			if err != nil {
+				continue
+			}
+			signer, err := ssh.ParsePrivateKey(data)
+			var missing *ssh.PassphraseMissingError
+			if errors.As(err, &missing) {
+				if len(signers) > 0 {
+					log.Debug("Skipping encrypted key ", identity, ", using the agent keys")
+					continue
+				}
+				signer, err = o.decryptKey(identity, data)
+			}
+			if err != nil {
+				log.Warn("Error loading key ", identity, ": ", err)
+				continue
+			}
+			log.Debug("Using key ", identity)
+			signers = append(signers, signer)
+		}
+		return signers, nil
+	}
+}
+
+func (o SSHOptions) decryptKey(identity string, data []byte) (ssh.Signer, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		passphrase, err := o.ask(fmt.Sprintf("Enter passphrase for key '%s': ", identity), true)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+		if !errors.Is(err, x509.IncorrectPasswordError) {
+			return signer, err
+		}
+		log.Warn("Wrong passphrase for key ", identity)
+	}
+	return nil, errors.New("too many wrong passphrases")
+}
+
+// hostKeyCallback checks host keys against the known hosts file. The key of
+// a host the file does not know is shown and, once the user trusts it,
+// added to the file. A host whose key changed is refused. The algorithms
+// are the types of the keys known for the host, so the server offers one of
+// them.
+func (o SSHOptions) hostKeyCallback(knownHostsFile string, addr string) (ssh.HostKeyCallback, []string, error) {
+	var known ssh.HostKeyCallback
+	if _, err := os.Stat(knownHostsFile); err == nil {
+		if known, err = knownhosts.New(knownHostsFile); err != nil {
+			return nil, nil, fmt.Errorf("error reading %s: %w", knownHostsFile, err)
+		}
+	}
+
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if known != nil {
+			err := known(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+				if err != nil && keyErr != nil {
+					return fmt.Errorf("host key of %s does not match the one in %s, someone may be intercepting the connection: %w", hostname, knownHostsFile, err)
+				}
+				return err
+			}
+		}
+
+		question := fmt.Sprintf("The authenticity of host '%s' can't be established.\n%s key fingerprint is %s.\nAre you sure you want to continue connecting (yes/no)? ",
+			hostname, key.Type(), ssh.FingerprintSHA256(key))
+		answer, err := o.ask(question, false)
+		if err != nil {
+			return fmt.Errorf("unknown host %s, add it to %s: %w", hostname, knownHostsFile, err)
+		}
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "yes" && answer != "y" {
+			return fmt.Errorf("host key of %s not trusted", hostname)
+		}
+		return addKnownHost(knownHostsFile, hostname, remote, key)
+	}
+
+	return callback, knownAlgorithms(known, addr), nil
+}
+
+func addKnownHost(knownHostsFile string, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(knownHostsFile), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(knownHostsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil && remote.String() != hostname {
+		addresses = append(addresses, knownhosts.Normalize(remote.String()))
+	}
+	if _, err := fmt.Fprintln(file, knownhosts.Line(addresses, key)); err != nil {
+		file.Close()
+		return err
+	}
+	log.Info("Added ", hostname, " to ", knownHostsFile)
+	return file.Close()
+}
+
+// knownAlgorithms asks the known hosts callback which keys it knows for the
+// address by checking a key it cannot know.
+func knownAlgorithms(known ssh.HostKeyCallback, addr string) []string {
+	if known == nil {
+		return nil
+	}
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(known(addr, &net.TCPAddr{}, probe), &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, want := range keyErr.Want {
+		switch want.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, want.Key.Type())
+		}
+	}
+	return algorithms
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
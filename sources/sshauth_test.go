@@ -0,0 +1,166 @@
+package sources
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseSSHTarget(t *testing.T) {
+	cases := []struct {
+		spec   string
+		target SSHTarget
+	}{
+		{"bob@example.com:/data", SSHTarget{User: "bob", Host: "example.com", Path: "/data"}},
+		{"bob@example.com:2222:/data", SSHTarget{User: "bob", Host: "example.com", Port: 2222, Path: "/data"}},
+		{"bob@nas:backups", SSHTarget{User: "bob", Host: "nas", Path: "backups"}},
+	}
+	for _, c := range cases {
+		target, err := ParseSSHTarget(c.spec)
+		assert.NoError(t, err, c.spec)
+		assert.Equal(t, c.target, target, c.spec)
+		assert.True(t, IsSSHTarget(c.spec), c.spec)
+	}
+
+	for _, spec := range []string{"bob@example.com", "bob@:/data", "bob@example.com:99999:/data"} {
+		_, err := ParseSSHTarget(spec)
+		assert.Error(t, err, spec)
+	}
+	assert.False(t, IsSSHTarget("/home/bob@work/data"))
+	assert.False(t, IsSSHTarget("https://bob@dav.example.com/data"))
+	assert.False(t, IsSSHTarget("sftp://bob@example.com/data"))
+	assert.False(t, IsSSHTarget("./mail/bob@example.com:2024"))
+}
+
+func TestReadSSHConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config")
+	assert.NoError(t, os.WriteFile(file, []byte(`
+# backups
+Host nas backup-*
+    HostName 192.168.1.10
+    Port 2222
+    IdentityFile ~/.ssh/nas_key
+
+Host *.example.com !skip.example.com
+    User alice
+
+Match host other
+    User mallory
+
+Host *
+    User bob
+    Port=22
+    IdentityFile "~/.ssh/id_%h"
+    UserKnownHostsFile ~/.ssh/known_hosts_cap
+`), 0600))
+
+	config, err := ReadSSHConfig(file, "nas")
+	assert.NoError(t, err)
+	assert.Equal(t, SSHHostConfig{
+		HostName:       "192.168.1.10",
+		User:           "bob",
+		Port:           2222,
+		IdentityFiles:  []string{"~/.ssh/nas_key", "~/.ssh/id_%h"},
+		KnownHostsFile: "~/.ssh/known_hosts_cap",
+	}, config)
+
+	config, err = ReadSSHConfig(file, "www.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", config.User)
+	assert.Equal(t, 22, config.Port)
+
+	config, err = ReadSSHConfig(file, "skip.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", config.User)
+
+	config, err = ReadSSHConfig(filepath.Join(t.TempDir(), "missing"), "nas")
+	assert.NoError(t, err)
+	assert.Equal(t, SSHHostConfig{}, config)
+
+	assert.Equal(t, filepath.Join("/home/bob", ".ssh", "id_nas"), expandSSHPath("~/.ssh/id_%h", "/home/bob", "nas", "root"))
+	assert.Equal(t, "/home/bob/keys/root%", expandSSHPath("%d/keys/%r%%", "/home/bob", "nas", "root"))
+}
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	key, err := ssh.NewPublicKey(public)
+	assert.NoError(t, err)
+	return key
+}
+
+func TestHostKeyTrustOnFirstUse(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2222}
+	hostname := "nas:2222"
+	key := newHostKey(t)
+
+	// No one to ask, an unknown host is refused.
+	callback, algorithms, err := SSHOptions{}.hostKeyCallback(knownHosts, hostname)
+	assert.NoError(t, err)
+	assert.Nil(t, algorithms)
+	assert.ErrorIs(t, callback(hostname, remote, key), ErrNoPrompt)
+
+	asked := 0
+	answer := "no"
+	options := SSHOptions{Prompt: func(question string, secret bool) (string, error) {
+		asked++
+		assert.False(t, secret)
+		assert.Contains(t, question, ssh.FingerprintSHA256(key))
+		return answer, nil
+	}}
+	callback, _, err = options.hostKeyCallback(knownHosts, hostname)
+	assert.NoError(t, err)
+	assert.Error(t, callback(hostname, remote, key))
+	assert.NoFileExists(t, knownHosts)
+
+	answer = "yes"
+	assert.NoError(t, callback(hostname, remote, key))
+	assert.FileExists(t, knownHosts)
+	assert.Equal(t, 2, asked)
+
+	// The host is known now, its key is accepted without asking and another
+	// key is refused.
+	callback, algorithms, err = options.hostKeyCallback(knownHosts, hostname)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, algorithms)
+	assert.NoError(t, callback(hostname, remote, key))
+	assert.Error(t, callback(hostname, remote, newHostKey(t)))
+	assert.Equal(t, 2, asked)
+}
+
+func TestDialSSHClosesAgent(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer listener.Close()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		_, err = conn.Read(make([]byte, 1))
+		closed <- err
+	}()
+
+	// nothing listens on the port, the connection is refused
+	port, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port.Close()
+	_, err = DialSSH(SSHTarget{Host: "127.0.0.1", Port: port.Addr().(*net.TCPAddr).Port, Path: "/"},
+		SSHOptions{ConfigFile: filepath.Join(t.TempDir(), "config"), KnownHostsFile: filepath.Join(t.TempDir(), "known_hosts")})
+	assert.Error(t, err)
+	assert.ErrorIs(t, <-closed, io.EOF)
+}
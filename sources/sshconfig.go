@@ -0,0 +1,162 @@
+package sources
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SSHTarget is where an SSH source points to. Port is 0 when the target does
+// not give one.
+type SSHTarget struct {
+	User string
+	Host string
+	Port int
+	Path string
+}
+
+// IsSSHTarget reports whether a source path is the user@host:path form of an
+// SSH target, sftp:// URLs are handled by the source registry.
+func IsSSHTarget(spec string) bool {
+	user, rest, ok := strings.Cut(spec, "@")
+	return ok && !strings.ContainsAny(user, "/\\") && strings.Contains(rest, ":") && !strings.Contains(spec, "://")
+}
+
+// ParseSSHTarget parses user@host:/path and user@host:port:/path, a path
+// without a leading slash is relative to the home directory.
+func ParseSSHTarget(spec string) (SSHTarget, error) {
+	var target SSHTarget
+	userinfo, rest, ok := strings.Cut(spec, "@")
+	if !ok {
+		return target, fmt.Errorf("invalid SSH target %q, use user@host:/path", spec)
+	}
+	target.User = userinfo
+	host, rest, ok := strings.Cut(rest, ":")
+	if !ok {
+		return target, fmt.Errorf("invalid SSH target %q, missing path", spec)
+	}
+	target.Host = host
+	// host:port:/path, a path never starts with a digit only segment
+	if port, remaining, ok := strings.Cut(rest, ":"); ok {
+		if p, err := strconv.Atoi(port); err == nil {
+			target.Port = p
+			rest = remaining
+		}
+	}
+	target.Path = rest
+
+	if target.Host == "" {
+		return target, fmt.Errorf("invalid SSH target %q, missing host", spec)
+	}
+	if target.Port < 0 || target.Port > 65535 {
+		return target, fmt.Errorf("invalid port %d in %q", target.Port, spec)
+	}
+	return target, nil
+}
+
+// SSHHostConfig is what ~/.ssh/config says about a host alias.
+type SSHHostConfig struct {
+	HostName       string
+	User           string
+	Port           int
+	IdentityFiles  []string
+	KnownHostsFile string
+}
+
+// ReadSSHConfig returns the options of the ssh config file applying to the
+// alias. As in OpenSSH the first value given for an option wins, except for
+// IdentityFile which adds up. A missing file has no options.
+func ReadSSHConfig(filename string, alias string) (SSHHostConfig, error) {
+	var config SSHHostConfig
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	defer file.Close()
+
+	matching := true
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.Replace(line, "=", " ", 1), " ")
+		key = strings.ToLower(key)
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		switch key {
+		case "host":
+			matching = matchHost(strings.Fields(value), alias)
+			continue
+		case "match":
+			log.Debug("Ignoring unsupported Match block in ", filename)
+			matching = false
+			continue
+		}
+		if !matching {
+			continue
+		}
+
+		switch key {
+		case "hostname":
+			if config.HostName == "" {
+				config.HostName = value
+			}
+		case "user":
+			if config.User == "" {
+				config.User = value
+			}
+		case "port":
+			if config.Port == 0 {
+				port, err := strconv.Atoi(value)
+				if err != nil {
+					return config, fmt.Errorf("%s: invalid port %q", filename, value)
+				}
+				config.Port = port
+			}
+		case "identityfile":
+			config.IdentityFiles = append(config.IdentityFiles, value)
+		case "userknownhostsfile":
+			if config.KnownHostsFile == "" {
+				config.KnownHostsFile = strings.Fields(value)[0]
+			}
+		}
+	}
+	return config, scanner.Err()
+}
+
+// matchHost applies the patterns of a Host line, a match on a negated
+// pattern excludes the host.
+func matchHost(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), host); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// expandSSHPath expands ~ and the %d (home), %u (local user), %h (host) and
+// %r (remote user) tokens of ssh config paths.
+func expandSSHPath(value string, home string, host string, remoteUser string) string {
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		value = filepath.Join(home, value[1:])
+	}
+	replacer := strings.NewReplacer("%%", "%", "%d", home, "%u", os.Getenv("USER"), "%h", host, "%r", remoteUser)
+	return replacer.Replace(value)
+}
@@ -0,0 +1,52 @@
+package sources
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileOwner returns the ownership and access time of a local file.
+func fileOwner(info os.FileInfo) (*Owner, time.Time) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, time.Time{}
+	}
+	owner := &Owner{Uid: int(stat.Uid), Gid: int(stat.Gid)}
+	owner.User, owner.Group = localUserName(owner.Uid), localGroupName(owner.Gid)
+	return owner, time.Unix(stat.Atimespec.Unix())
+}
+
+// fileIdentity returns the device and inode the hard links of a local file
+// share, their number and the device number of a device file.
+func fileIdentity(info os.FileInfo) (dev, ino, nlink, rdev uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino), uint64(stat.Nlink), uint64(stat.Rdev)
+}
+
+// makeSpecial creates a fifo, or a character or block device.
+func makeSpecial(name string, mode os.FileMode, rdev uint64) error {
+	perm := uint32(mode.Perm())
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return syscall.Mkfifo(name, perm)
+	case mode&os.ModeCharDevice != 0:
+		return syscall.Mknod(name, syscall.S_IFCHR|perm, int(rdev))
+	case mode&os.ModeDevice != 0:
+		return syscall.Mknod(name, syscall.S_IFBLK|perm, int(rdev))
+	}
+	return fmt.Errorf("%s is neither a fifo nor a device", name)
+}
+
+// changeTime returns the last change of the inode of a local file.
+func changeTime(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(stat.Ctimespec.Unix())
+}
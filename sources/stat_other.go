@@ -0,0 +1,30 @@
+//go:build !linux && !darwin
+
+package sources
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// fileOwner returns nothing, the ownership is not read on this system.
+func fileOwner(info os.FileInfo) (*Owner, time.Time) {
+	return nil, time.Time{}
+}
+
+// fileIdentity returns nothing, the hard links are not detected on this
+// system.
+func fileIdentity(info os.FileInfo) (dev, ino, nlink, rdev uint64) {
+	return 0, 0, 0, 0
+}
+
+// makeSpecial fails, fifos and devices cannot be created on this system.
+func makeSpecial(name string, mode os.FileMode, rdev uint64) error {
+	return fmt.Errorf("cannot create %s: fifos and devices are not supported on this system", name)
+}
+
+// changeTime returns nothing, the change time is not read on this system.
+func changeTime(info os.FileInfo) time.Time {
+	return time.Time{}
+}
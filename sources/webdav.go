@@ -3,14 +3,17 @@ package sources
 import (
 	"bytes"
 	"crypto/sha1"
+	"crypto/tls"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -19,6 +22,10 @@ type WebDAVSource struct {
 	Server   string
 	Username string
 	Password string
+	// Filter restricts the files ListFiles returns, nil lists everything.
+	Filter *Filter
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
 }
 
 func NewWebDAVSource(server, username, password string) (*WebDAVSource, error) {
@@ -29,6 +36,72 @@ func NewWebDAVSource(server, username, password string) (*WebDAVSource, error) {
 	}, nil
 }
 
+func init() {
+	for _, scheme := range []string{"webdav", "webdavs", "http", "https"} {
+		Register(scheme, openWebDAV)
+	}
+}
+
+// openWebDAV opens webdav:// and webdavs:// URLs, served over http and https,
+// and the plain http(s) URLs of older versions. The timeout option limits
+// every request and insecure=true accepts any TLS certificate.
+func openWebDAV(u *url.URL, options Options) (Source, error) {
+	query, err := queryOptions(u, "timeout", "insecure")
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{}
+	if timeout := query.Get("timeout"); timeout != "" {
+		if client.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid webdav timeout %q: %w", timeout, err)
+		}
+	}
+	if insecure := query.Get("insecure"); insecure != "" {
+		skip, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webdav insecure %q: %w", insecure, err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: skip}
+		client.Transport = transport
+	}
+
+	server := *u
+	server.User, server.RawQuery, server.Fragment = nil, "", ""
+	switch strings.ToLower(u.Scheme) {
+	case "webdav":
+		server.Scheme = "http"
+	case "webdavs":
+		server.Scheme = "https"
+	}
+	if !strings.HasSuffix(server.Path, "/") {
+		server.Path += "/"
+		server.RawPath = ""
+	}
+
+	if options.User == "" {
+		return nil, errors.New("user not provided for WebDAV source")
+	}
+	if options.Password == "" && options.Prompt != nil {
+		if options.Password, err = options.Prompt(fmt.Sprintf("Password for %s@%s: ", options.User, server.Host), true); err != nil {
+			return nil, err
+		}
+	}
+	source, err := NewWebDAVSource(server.String(), options.User, options.Password)
+	if err != nil {
+		return nil, err
+	}
+	source.Client = client
+	return source, nil
+}
+
+func (w *WebDAVSource) client() *http.Client {
+	if w.Client == nil {
+		return http.DefaultClient
+	}
+	return w.Client
+}
+
 func (w *WebDAVSource) CalculateFileHash(filebyte []byte) (string, error) {
 	hash := sha1.New()
 	hash.Write(filebyte)
@@ -58,51 +131,94 @@ func ConvertTimeFromRFC3339(timeString string) (time.Time, error) {
 
 }
 
+// davListing is the multistatus response of a PROPFIND listing the files.
+type davListing struct {
+	Responses []struct {
+		Href  string `xml:"href"`
+		Props struct {
+			DisplayName   string `xml:"displayname"`
+			ContentLength string `xml:"getcontentlength"`
+		} `xml:"propstat>prop"`
+	} `xml:"response"`
+}
+
+// propfind lists everything under the server path, the first response is
+// the base path itself.
+func (w *WebDAVSource) propfind() (*davListing, error) {
+	req, err := http.NewRequest("PROPFIND", w.Server, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(w.Username, w.Password)
+	req.Header.Set("Depth", "1000")
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("unexpected status code listing files: %s", resp.Status)
+	}
+	var multistatus davListing
+	if err := xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return nil, fmt.Errorf("error decoding listing: %w", err)
+	}
+	if len(multistatus.Responses) == 0 {
+		return nil, errors.New("empty listing")
+	}
+	return &multistatus, nil
+}
+
+// ListNames lists the paths of the files without reading them.
+func (w *WebDAVSource) ListNames() ([]string, error) {
+	multistatus, err := w.propfind()
+	if err != nil {
+		return nil, err
+	}
+	basePath := multistatus.Responses[0].Href
+	var names []string
+	for _, response := range multistatus.Responses {
+		if strings.HasSuffix(response.Href, "/") {
+			continue
+		}
+		name, err := url.QueryUnescape(strings.TrimPrefix(response.Href, basePath))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
 func (w *WebDAVSource) ListFiles() <-chan FileInfo {
 	ch := make(chan FileInfo)
 	go func() {
 		defer close(ch)
-		// Create an HTTP request to list files
-		req, err := http.NewRequest("PROPFIND", w.Server, nil)
+		multistatus, err := w.propfind()
 		if err != nil {
-			log.Error("Error creating request: ", err)
-			return
-		}
-		req.SetBasicAuth(w.Username, w.Password)
-		req.Header.Set("Depth", "1000")
-
-		// Perform the HTTP request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Error("Error performing request: ", err)
-			return
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusMultiStatus {
-			log.Error("Unexpected status code: ", resp.StatusCode)
-			return
-		}
-
-		// Parse the XML response
-		var multistatus struct {
-			Responses []struct {
-				Href  string `xml:"href"`
-				Props struct {
-					DisplayName string `xml:"displayname"`
-					Permissions string `xml:"getcontentlength"`
-				} `xml:"propstat>prop"`
-			} `xml:"response"`
-		}
-		if err := xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
-			log.Error("Error decoding response: ", err)
+			log.Error("Error listing files: ", err)
 			return
 		}
 
 		basePath := multistatus.Responses[0].Href // Assuming the first response contains the base path
 
 		log.Info("Base path: ", basePath)
+		// the listing is flat, the ignore files are loaded before any file
+		// they may exclude
+		walk := w.Filter.Walk()
+		if walk != nil {
+			for _, response := range multistatus.Responses {
+				remote_path, err := url.QueryUnescape(strings.TrimPrefix(response.Href, basePath))
+				if err != nil || path.Base(remote_path) != IgnoreFileName {
+					continue
+				}
+				if data, err := w.GetFile(remote_path); err == nil {
+					walk.AddIgnoreFile(path.Dir(remote_path), data)
+				}
+			}
+		}
 		// Send FileInfo objects to the channel
 		for _, response := range multistatus.Responses {
 
@@ -119,14 +235,19 @@ func (w *WebDAVSource) ListFiles() <-chan FileInfo {
 					return
 				}
 
-				md5, error := w.GetFileHash(remote_path)
-				if error != nil {
-					log.Error("Error getting file hash:", error)
-				}
 				LastModified, error := w.GetFileLastModified(remote_path)
 				if error != nil {
 					log.Error("Error getting file last modified:", error)
 				}
+				size, _ := strconv.ParseInt(response.Props.ContentLength, 10, 64)
+				if !walk.Keep(remote_path, size, LastModified) {
+					log.Debug("Skipping excluded file: ", remote_path)
+					continue
+				}
+				md5, error := w.GetFileHash(remote_path)
+				if error != nil {
+					log.Error("Error getting file hash:", error)
+				}
 				// LastModifiedTm, error := ConvertTimeFromRFC3339(LastModified)
 				// if error != nil {
 				// 	log.Error("Error converting file last modified:", error)
@@ -136,7 +257,8 @@ func (w *WebDAVSource) ListFiles() <-chan FileInfo {
 					Path:         remote_path,
 					Md5:          md5,
 					Filename:     path.Base(remote_path),
-					Permission:   response.Props.Permissions,
+					Permission:   "-rw-r--r--",
+					Size:         size,
 					RemoteHash:   "",
 					LastModified: LastModified,
 				}
@@ -154,7 +276,7 @@ func (w *WebDAVSource) GetFile(path string) ([]byte, error) {
 	}
 	req.SetBasicAuth(w.Username, w.Password)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := w.client().Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -167,6 +289,70 @@ func (w *WebDAVSource) GetFile(path string) ([]byte, error) {
 	return io.ReadAll(resp.Body)
 }
 
+func (w *WebDAVSource) OpenFile(path string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", w.Server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(w.Username, w.Password)
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s: %w", path, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s: %s", path, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// webdavWriter streams the written data as the body of a PUT request.
+type webdavWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (ww *webdavWriter) Write(p []byte) (int, error) {
+	return ww.pipe.Write(p)
+}
+
+func (ww *webdavWriter) Close() error {
+	if err := ww.pipe.Close(); err != nil {
+		return err
+	}
+	return <-ww.done
+}
+
+func (w *WebDAVSource) CreateFile(path string, permission string) (io.WriteCloser, error) {
+	body, pipe := io.Pipe()
+	req, err := http.NewRequest("PUT", w.Server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(w.Username, w.Password)
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := w.client().Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+				log.Error("Error saving file:", resp.Status)
+				err = errors.New("failed to save file")
+			}
+		}
+		// unblock pending writes when the server gave up on the body
+		body.CloseWithError(err)
+		done <- err
+	}()
+	return &webdavWriter{pipe: pipe, done: done}, nil
+}
+
 func (w *WebDAVSource) SaveFile(path string, data []byte, permission string) error {
 	log.Info("Saving file to WebDAV: ", w.Server, "pall  ", path)
 	body := bytes.NewReader(data)
@@ -177,7 +363,7 @@ func (w *WebDAVSource) SaveFile(path string, data []byte, permission string) err
 	}
 	req.SetBasicAuth(w.Username, w.Password)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := w.client().Do(req)
 	if err != nil {
 		log.Error("Error sending request:", err)
 		return err
@@ -199,7 +385,7 @@ func (w *WebDAVSource) Exists(path string) bool {
 	}
 	req.SetBasicAuth(w.Username, w.Password)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := w.client().Do(req)
 	if err != nil {
 		return false
 	}
@@ -238,7 +424,7 @@ type Checksums struct {
 }
 
 func (w *WebDAVSource) GetFileHash(remote_path string) (string, error) {
-	client := &http.Client{}
+	client := w.client()
 	body := `<?xml version="1.0" encoding="utf-8" ?>
 		<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
 			<d:prop><oc:checksums/></d:prop>
@@ -288,7 +474,7 @@ func (w *WebDAVSource) RemoveFile(path string) error {
 	}
 	req.SetBasicAuth(w.Username, w.Password)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := w.client().Do(req)
 	if err != nil {
 		return err
 	}
@@ -301,8 +487,76 @@ func (w *WebDAVSource) RemoveFile(path string) error {
 	return nil
 }
 
+// RemoveDir deletes a collection once a PROPFIND shows it is empty, DELETE
+// would remove what it holds too.
+func (w *WebDAVSource) RemoveDir(path string) error {
+	dir := strings.TrimSuffix(w.Server+path, "/") + "/"
+	req, err := http.NewRequest("PROPFIND", dir, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(w.Username, w.Password)
+	req.Header.Set("Depth", "1")
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return fmt.Errorf("failed to list directory: %s", resp.Status)
+	}
+	var multistatus struct {
+		Responses []struct {
+			Href string `xml:"href"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return fmt.Errorf("failed to decode directory listing: %w", err)
+	}
+	// the first response is the collection itself
+	if len(multistatus.Responses) > 1 {
+		return fmt.Errorf("directory %s is not empty", path)
+	}
+
+	req, err = http.NewRequest("DELETE", dir, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(w.Username, w.Password)
+	resp, err = w.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to remove directory: %s", resp.Status)
+	}
+	return nil
+}
+
+func (w *WebDAVSource) RenameFile(oldpath string, newpath string) error {
+	req, err := http.NewRequest("MOVE", w.Server+oldpath, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(w.Username, w.Password)
+	req.Header.Set("Destination", w.Server+newpath)
+	req.Header.Set("Overwrite", "T")
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to move file: %s", resp.Status)
+	}
+	return nil
+}
+
 func (w *WebDAVSource) GetFileLastModified(remote_path string) (time.Time, error) {
-	client := &http.Client{}
+	client := w.client()
 	body := `<?xml version="1.0" encoding="utf-8" ?>
 		<d:propfind xmlns:d="DAV:">
 			<d:prop><d:getlastmodified/></d:prop>
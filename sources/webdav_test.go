@@ -0,0 +1,49 @@
+package sources
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebDAVRemoveDir(t *testing.T) {
+	// the collections and the names they hold
+	collections := map[string][]string{"/dav/empty/": nil, "/dav/full/": {"a.txt"}}
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		children, ok := collections[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case "PROPFIND":
+			assert.Equal(t, "1", r.Header.Get("Depth"))
+			var body strings.Builder
+			fmt.Fprintf(&body, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>%s</d:href></d:response>`, r.URL.Path)
+			for _, child := range children {
+				fmt.Fprintf(&body, `<d:response><d:href>%s%s</d:href></d:response>`, r.URL.Path, child)
+			}
+			body.WriteString(`</d:multistatus>`)
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, body.String())
+		case "DELETE":
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	source := &WebDAVSource{Server: server.URL + "/dav/", Client: server.Client()}
+	require.NoError(t, source.RemoveDir("empty"))
+	assert.ErrorContains(t, source.RemoveDir("full"), "not empty")
+	assert.Error(t, source.RemoveDir("missing"))
+	assert.Equal(t, []string{"/dav/empty/"}, deleted)
+}
@@ -0,0 +1,63 @@
+package sources
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Xattrs chooses the extended attributes a local listing reads: none when
+// Disabled, otherwise the ones of Namespaces (user, security, system or
+// trusted), every one when it is empty. The POSIX ACLs are the
+// system.posix_acl_access and system.posix_acl_default attributes, the
+// SELinux label is security.selinux.
+type Xattrs struct {
+	Disabled   bool
+	Namespaces []string
+}
+
+// Keep reports whether an attribute name is in one of the namespaces.
+func (x Xattrs) Keep(name string) bool {
+	if x.Disabled {
+		return false
+	}
+	if len(x.Namespaces) == 0 {
+		return true
+	}
+	namespace, _, _ := strings.Cut(name, ".")
+	for _, wanted := range x.Namespaces {
+		if strings.TrimSuffix(wanted, ".") == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// privilegedXattr tells the attributes only root can set.
+func privilegedXattr(name string) bool {
+	return strings.HasPrefix(name, "trusted.") || strings.HasPrefix(name, "security.")
+}
+
+// WithXattrs sets the extended attributes the listings of a local source
+// read, the other sources have none.
+func WithXattrs(source Source, xattrs Xattrs) Source {
+	if s, ok := source.(Localsource); ok {
+		s.Xattrs = xattrs
+		return s
+	}
+	return source
+}
+
+// readXattrs returns the extended attributes of a local file kept by the
+// listing, nil when it has none or they cannot be read.
+func (l Localsource) readXattrs(name string) map[string][]byte {
+	if l.Xattrs.Disabled {
+		return nil
+	}
+	attrs, err := listXattrs(name, l.Xattrs.Keep)
+	if err != nil {
+		log.Debug("Error reading extended attributes of ", name, ": ", err)
+		return nil
+	}
+	return attrs
+}
@@ -0,0 +1,99 @@
+package sources
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// listXattrs reads the extended attributes of a file kept by keep, without
+// following a symbolic link. File systems without attributes have none.
+func listXattrs(name string, keep func(string) bool) (map[string][]byte, error) {
+	var names []byte
+	for {
+		size, err := unix.Llistxattr(name, nil)
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		names = make([]byte, size)
+		size, err = unix.Llistxattr(name, names)
+		// the list grew since its size was asked
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = names[:size]
+		break
+	}
+
+	var attrs map[string][]byte
+	for _, attr := range strings.Split(string(names), "\x00") {
+		if attr == "" || !keep(attr) {
+			continue
+		}
+		value, err := getXattr(name, attr)
+		if errors.Is(err, unix.ENODATA) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", attr, err)
+		}
+		if attrs == nil {
+			attrs = map[string][]byte{}
+		}
+		attrs[attr] = value
+	}
+	return attrs, nil
+}
+
+func getXattr(name string, attr string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(name, attr, nil)
+		if err != nil || size == 0 {
+			return []byte{}, err
+		}
+		value := make([]byte, size)
+		size, err = unix.Lgetxattr(name, attr, value)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		return value[:size], err
+	}
+}
+
+// lsetxattr is replaced by the tests.
+var lsetxattr = unix.Lsetxattr
+
+// setXattrs sets the extended attributes of a file, without following a
+// symbolic link. The trusted and security ones are only set by root, the
+// ones the file system does not support are left out with a warning.
+func setXattrs(name string, attrs map[string][]byte, root bool) error {
+	names := make([]string, 0, len(attrs))
+	for attr := range attrs {
+		names = append(names, attr)
+	}
+	sort.Strings(names)
+	for _, attr := range names {
+		if privilegedXattr(attr) && !root {
+			continue
+		}
+		err := lsetxattr(name, attr, attrs[attr], 0)
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+			log.Warn("Extended attribute ", attr, " of ", name, " is not supported by the file system, leaving it out")
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", attr, err)
+		}
+	}
+	return nil
+}
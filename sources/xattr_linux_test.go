@@ -0,0 +1,36 @@
+package sources
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSetXattrsUnsupported(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(name, []byte("a"), 0644))
+
+	var set []string
+	lsetxattr = func(path string, attr string, data []byte, flags int) error {
+		if attr == "user.unsupported" {
+			return unix.EOPNOTSUPP
+		}
+		if attr == "user.denied" {
+			return unix.EPERM
+		}
+		set = append(set, attr)
+		return nil
+	}
+	defer func() { lsetxattr = unix.Lsetxattr }()
+
+	attrs := map[string][]byte{"user.unsupported": []byte("1"), "user.comment": []byte("kept")}
+	require.NoError(t, setXattrs(name, attrs, false))
+	assert.Equal(t, []string{"user.comment"}, set)
+
+	attrs["user.denied"] = []byte("1")
+	assert.ErrorIs(t, setXattrs(name, attrs, false), unix.EPERM)
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package sources
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// listXattrs returns nothing, the extended attributes are only read on
+// linux.
+func listXattrs(name string, keep func(string) bool) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// setXattrs leaves the extended attributes of a backup made on linux out.
+func setXattrs(name string, attrs map[string][]byte, root bool) error {
+	if len(attrs) > 0 {
+		log.Warn("Not restoring the extended attributes of ", name, ", they are only supported on linux")
+	}
+	return nil
+}
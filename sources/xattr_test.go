@@ -0,0 +1,21 @@
+package sources
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXattrsKeep(t *testing.T) {
+	all := Xattrs{}
+	assert.True(t, all.Keep("user.comment"))
+	assert.True(t, all.Keep("system.posix_acl_access"))
+
+	acls := Xattrs{Namespaces: []string{"system", "security."}}
+	assert.True(t, acls.Keep("system.posix_acl_default"))
+	assert.True(t, acls.Keep("security.selinux"))
+	assert.False(t, acls.Keep("user.comment"))
+	assert.False(t, acls.Keep("systemd.unit"))
+
+	assert.False(t, Xattrs{Disabled: true}.Keep("user.comment"))
+}